@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheKey identifies a cached export by everything that shapes its rows
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// exportCacheKey returns the cache key of an export of the search source
+// query. Every setting that changes which rows are written, or how, is part
+// of it, so a new one must be added here too.
+func exportCacheKey(cfg Config, query string) string {
+	flags := []bool{cfg.Gzip, cfg.EmbedQuery, cfg.FirstOnly, cfg.IncludeMeta, cfg.IncludeRawIndex, cfg.NoPasswordOutput,
+		cfg.MaskPasswords, cfg.RequirePassword, cfg.IncludeEmpty, cfg.Dedup, cfg.HashcatUser}
+	parts := []string{cfg.InputURL, cfg.CloudID, cfg.Cluster, cfg.Index, query,
+		strconv.Itoa(cfg.Limit), cfg.Sort, cfg.SortBy, cfg.Fields, cfg.Columns, cfg.Format, cfg.Delimiter,
+		cfg.EncodeFields, cfg.MaxFieldAction, strconv.Itoa(cfg.MaxFieldLength), cfg.NullValues, cfg.DedupField,
+		cfg.HashField, cfg.SaltField, cfg.SearchTerm, strings.Join(cfg.Passes, "\n")}
+	for _, flag := range flags {
+		parts = append(parts, strconv.FormatBool(flag))
+	}
+	return cacheKey(parts...)
+}
+
+func cachePath(dir, key string) string {
+	return filepath.Join(dir, key+".csv")
+}
+
+// loadCache copies a cached export younger than ttl to outfile, reporting
+// whether it did so. Expired entries are removed.
+func loadCache(dir, key, outfile string, ttl time.Duration) (bool, error) {
+	path := cachePath(dir, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if time.Since(info.ModTime()) > ttl {
+		return false, os.Remove(path)
+	}
+	return true, copyFile(path, outfile)
+}
+
+// storeCache saves a finished export under key, replacing any previous entry
+func storeCache(dir, key, outfile string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := copyFile(outfile, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath(dir, key))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// exposure is the result of checking a single term
+type exposure struct {
+	breaches int
+	err      error
+}
+
+// checkTerm counts the breaches a term appears in, with a terms aggregation on
+// _index instead of fetching any hit
+func checkTerm(ctx context.Context, client *elastic.Client, cfg Config, inputType, term string) (int, error) {
+	termCfg := cfg
+	setSearchTerm(&termCfg, inputType, term)
+	indices := hoardd.SplitList(cfg.Index)
+	query := buildQuery(termCfg)
+	compiled, err := query.Compile(ctx, client, indices...)
+	if err != nil {
+		return 0, err
+	}
+	breachAgg := elastic.NewTermsAggregation().Field("_index").Size(maxSampleIndices)
+	src := elastic.NewSearchSource().Query(compiled.Query).Size(0).Aggregation("breaches", breachAgg)
+	if cfg.MinScore > 0 {
+		src = src.MinScore(cfg.MinScore)
+	}
+	res, err := client.Search(indices...).SearchSource(src).Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	breaches, ok := res.Aggregations.Terms("breaches")
+	if !ok {
+		return 0, nil
+	}
+	// indices of the same breach count once
+	names := make(map[string]bool)
+	for _, c := range breachCounts(breaches) {
+		names[c.Breach] = true
+	}
+	return len(names), nil
+}
+
+// runCheck checks every term for exposure, up to workers at a time, and
+// writes a term,exposed,breach_count row per term to cfg.Outfile in the
+// order of terms. Malformed terms are skipped with a warning. It returns the
+// number of failed checks after logging a summary.
+func runCheck(ctx context.Context, client *elastic.Client, cfg Config, inputType string, terms []string, workers int) int {
+	results := make([]*exposure, len(terms))
+	malformed := make([]bool, len(terms))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, term := range terms {
+		if err := validateTerm(inputType, term); err != nil {
+			warnf("skipping malformed %s %q (term %d): %s", inputType, term, i+1, err)
+			malformed[i] = true
+			continue
+		}
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, term string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			breaches, err := checkTerm(ctx, client, cfg, inputType, term)
+			results[i] = &exposure{breaches: breaches, err: err}
+		}(i, term)
+	}
+	wg.Wait()
+
+	f, err := createOutput(cfg.Outfile)
+	if err != nil {
+		errorf("error creating %s: %s", cfg.Outfile, err)
+		return len(terms)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	delimiter, _ := parseDelimiter(cfg.Delimiter)
+	rows := rowFormat{delimiter: delimiter}
+	w := bufio.NewWriter(f)
+	// the first write error is reported once the summary is counted
+	_, err = w.WriteString(rows.csvLine([]string{inputType, "exposed", "breach_count"}))
+	checked, exposed, skipped, failed, unchecked := 0, 0, 0, 0, 0
+	for i, term := range terms {
+		result := results[i]
+		switch {
+		case malformed[i]:
+			skipped++
+		case result == nil || result.err != nil && ctx.Err() != nil:
+			unchecked++
+		case result.err != nil:
+			errorf("error checking %s %q: %s", inputType, term, result.err)
+			failed++
+		default:
+			checked++
+			if result.breaches > 0 {
+				exposed++
+			}
+			if err == nil {
+				_, err = w.WriteString(rows.csvLine([]string{term, strconv.FormatBool(result.breaches > 0), strconv.Itoa(result.breaches)}))
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = commitOutput(f, cfg.Outfile)
+	}
+	if err != nil {
+		errorf("error writing %s: %s", cfg.Outfile, err)
+		return len(terms)
+	}
+	if unchecked > 0 {
+		infof("%s, %d of %d terms not checked", stopError(ctx), unchecked, len(terms))
+	}
+	infof("check summary: %d terms, %d exposed, %d not exposed, %d malformed, %d failed, written to %s",
+		len(terms), exposed, checked-exposed, skipped, failed, cfg.Outfile)
+	return failed
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// checkpoint records the progress of an export so it can continue after a
+// failure. It is rewritten after every flushed batch.
+type checkpoint struct {
+	// Key identifies the query, index, sort and outfile of the export
+	Key      string `json:"key"`
+	ScrollID string `json:"scroll_id"`
+	// Hits processed and Rows written to the outfile, which is Offset bytes long
+	Hits   int64 `json:"hits"`
+	Rows   int64 `json:"rows"`
+	Offset int64 `json:"offset"`
+	// SearchAfter holds the sort values of the last processed hit
+	SearchAfter []interface{} `json:"search_after"`
+}
+
+// loadCheckpoint reads a checkpoint file, returning nil if it doesn't exist
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// save replaces the checkpoint file atomically
+func (cp *checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkpointPages continues the scroll scrollID, where after holds the sort
+// values of the last hit written, and sends its pages on the returned channel
+// like scrollSlices. When the scroll has expired, the remaining hits are paged
+// with search_after from the last hit sent. Pages are handed over unbuffered,
+// so every page sent was taken by the consumer, which alone owns the
+// checkpoint.
+func checkpointPages(ctx context.Context, scrollID string, after []interface{}, continueScroll func(scrollID string) *elastic.ScrollService,
+	searchAfter func(after []interface{}) *elastic.SearchService) <-chan scrollBatch {
+	batches := make(chan scrollBatch)
+	send := func(batch scrollBatch) bool {
+		select {
+		case batches <- batch:
+			if batch.after != nil {
+				after = batch.after
+			}
+			return batch.err == nil
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer closeBatches(ctx, batches)
+		if scrollID != "" {
+			q := continueScroll(scrollID)
+			for {
+				start := time.Now()
+				result, err := doWithRetry(ctx, q.Do)
+				if err == io.EOF {
+					return
+				} else if err != nil && ctx.Err() == nil && after != nil {
+					// scroll contexts expire after the keep alive
+					warnf("could not continue the scroll (%s), continuing with search_after", err)
+					break
+				}
+				if !send(newBatch(result, start, err)) {
+					return
+				}
+			}
+		}
+		pages := searchAfterPages(ctx, searchAfter, after)
+		for batch := range pages {
+			if !send(batch) {
+				drainBatches(pages)
+				return
+			}
+		}
+	}()
+	return batches
+}
+
+// advance moves cp past a written batch, to the scroll and sort values that
+// continue after it
+func (cp *checkpoint) advance(batch scrollBatch) {
+	if batch.result.ScrollId != "" {
+		cp.ScrollID = batch.result.ScrollId
+	}
+	if batch.after != nil {
+		cp.SearchAfter = batch.after
+	}
+}
+
+// saveCheckpoint records the hits and rows written so far in cp, already
+// advanced past the last flushed batch, and saves it
+func saveCheckpoint(path string, cp *checkpoint, f *os.File, hits, rows int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cp.Hits, cp.Rows, cp.Offset = hits, rows, info.Size()
+	return cp.save(path)
+}
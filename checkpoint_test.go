@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// page returns a page of hits with the given sort values
+func page(scrollID string, sorts ...[]interface{}) *elastic.SearchResult {
+	hits := &elastic.SearchHits{}
+	for _, sort := range sorts {
+		hits.Hits = append(hits.Hits, &elastic.SearchHit{Sort: sort})
+	}
+	return &elastic.SearchResult{ScrollId: scrollID, Hits: hits}
+}
+
+func TestCheckpointAdvance(t *testing.T) {
+	var position checkpoint
+	steps := []struct {
+		result   *elastic.SearchResult
+		scrollID string
+		after    []interface{}
+	}{
+		{page("scroll-1", []interface{}{"a", "1"}, []interface{}{"b", "2"}), "scroll-1", []interface{}{"b", "2"}},
+		// every batch moves on, not just the first after a reconnect
+		{page("scroll-2", []interface{}{"c", "3"}), "scroll-2", []interface{}{"c", "3"}},
+		// search_after pages have no scroll id, the last one is kept
+		{page("", []interface{}{"d", "4"}), "scroll-2", []interface{}{"d", "4"}},
+		// an empty page continues from where the last one ended
+		{page(""), "scroll-2", []interface{}{"d", "4"}},
+	}
+	for i, step := range steps {
+		position.advance(newBatch(step.result, time.Now(), nil))
+		if position.ScrollID != step.scrollID || !reflect.DeepEqual(position.SearchAfter, step.after) {
+			t.Errorf("after batch %d: position = %q %v, want %q %v", i+1, position.ScrollID, position.SearchAfter, step.scrollID, step.after)
+		}
+	}
+}
@@ -8,55 +8,217 @@ package main
 
 // todo
 // multiple file type outputs
-// parse full hit dynamically (address, etc)
-// don't do everything in main like a pleb
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"net/url"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"filippo.io/age"
 	"github.com/cheggaaa/pb/v3"
+	"github.com/hoardd/hoardd-client/hoardd"
 	"github.com/matryer/try"
 	"github.com/olivere/elastic/v7"
-	"gopkg.in/yaml.v2"
+	"golang.org/x/term"
+	"golang.org/x/time/rate"
 )
 
-// standard error checking
-func check(e error) {
-	if e != nil {
-		log.Fatalf("Fatal error: %s", e)
-	}
-}
-
 // Config definition from YAML
 type Config struct {
 	InputURL string `yaml:"url"`
+	// CloudID addresses an Elastic Cloud deployment in place of InputURL
+	CloudID  string `yaml:"cloud_id"`
 	Index    string `yaml:"index"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	Outfile  string `yaml:"outfile"`
 	Verbose  bool   `yaml:"verbose"`
 	Debug    bool   `yaml:"debug"`
+	// LogLevel is the lowest level logged, by default info, or debug with
+	// Verbose or Debug
+	LogLevel string `yaml:"log_level"`
 	Limit    int    `yaml:"limit"`
 	Domain   string `yaml:"domain"`
 	Email    string `yaml:"email"`
-	Pass     string `yaml:"pass"`
+	// EmailPattern is a wildcard pattern of the email, or of its local part in Domain
+	EmailPattern string `yaml:"email_pattern"`
+	Pass         string `yaml:"pass"`
+	IP           string `yaml:"ip"`
+	IPField      string `yaml:"ip_field"`
+	// CIDR limits any search to results with an IPField in the range
+	CIDR string `yaml:"cidr"`
+	// Contains is a fragment matched anywhere in the email, which needs
+	// AllowSlow as it starts with a wildcard
+	Contains  string `yaml:"contains"`
+	AllowSlow bool   `yaml:"allow_slow"`
+	// User searches the username field, Username is the Elasticsearch login
+	User string `yaml:"user"`
+	Hash string `yaml:"hash"`
+	// HashField is the field searched for hash, and written by hashcat output
+	HashField string `yaml:"hash_field"`
+	// SaltField is the field appended to the hash by hashcat output, empty
+	// writes bare hashes
+	SaltField string `yaml:"salt_field"`
+	// HashcatUser starts hashcat lines with the email, for hashcat --username
+	HashcatUser bool `yaml:"hashcat_user"`
+	// Phone is normalized and searched in PhoneField
+	Phone      string `yaml:"phone"`
+	PhoneField string `yaml:"phone_field"`
+	// QueryFile holds a raw query DSL searched instead, loaded into RawQuery
+	QueryFile string `yaml:"query_file"`
+	RawQuery  string `yaml:"-"`
+	// PassFile lists password candidates searched at once, loaded into Passes
+	PassFile string   `yaml:"pass_file"`
+	Passes   []string `yaml:"-"`
+	// MaxFieldLength caps the size in bytes of any single output field, 0 disables the guard
+	MaxFieldLength int    `yaml:"max_field_length"`
+	MaxFieldAction string `yaml:"max_field_action"`
+	EncodeFields   string `yaml:"encode_fields"`
+	// IncludeRawIndex adds the unmodified _index as a raw_index column
+	IncludeRawIndex bool `yaml:"include_raw_index"`
+	// IncludeMeta adds the _id, _index and _score of every hit as columns
+	IncludeMeta bool `yaml:"include_meta"`
+	// Highlight adds the highlighted match as a matched_context column
+	Highlight bool `yaml:"highlight"`
+	// NoPasswordOutput never fetches or writes the password field
+	NoPasswordOutput bool `yaml:"no_password_output"`
+	// MaskPasswords writes only the first and last character and length of passwords
+	MaskPasswords  bool   `yaml:"mask_passwords"`
+	MinShouldMatch string `yaml:"min_should_match"`
+	NormalizeEmail bool   `yaml:"normalize_email"`
+	// MaxQueryTime is the budget for the count and first batch before warning
+	MaxQueryTime time.Duration `yaml:"max_query_time"`
+	ListFields   bool          `yaml:"list_fields"`
+	// Sample prints the first Sample hits instead of exporting
+	Sample    int  `yaml:"sample"`
+	CountOnly bool `yaml:"count_only"`
+	// FirstOnly writes a single result per email
+	FirstOnly bool `yaml:"first_only"`
+	// Columns selects, orders and renames the CSV columns, column[:header] items
+	Columns string `yaml:"columns"`
+	// EmbedQuery writes the query, index, time and total ahead of the results
+	EmbedQuery bool `yaml:"embed_query"`
+	// Summary prints the number of matches per breach instead of exporting
+	Summary bool `yaml:"summary"`
+	// MinScore drops hits scoring below it server-side, 0 disables the threshold
+	MinScore float64 `yaml:"min_score"`
+	// ExpectCount fails the search unless the count query matches it, give or
+	// take ExpectTolerance, -1 disables the check
+	ExpectCount     int64 `yaml:"expect_count"`
+	ExpectTolerance int64 `yaml:"expect_tolerance"`
+	// RequirePassword skips results without a password, IncludeEmpty keeps
+	// results without an email
+	RequirePassword bool `yaml:"require_password"`
+	IncludeEmpty    bool `yaml:"include_empty"`
+	// NullValues are comma-separated junk values, compared ignoring case, that
+	// count as empty when skipping results
+	NullValues string `yaml:"null_values"`
+	// JSONLog writes progress as JSON lines to stderr instead of the progress bar
+	JSONLog bool `yaml:"json_log"`
+	// NoProgress logs progress lines instead of the progress bar, which is
+	// also disabled when stderr is not a terminal
+	NoProgress bool `yaml:"no_progress"`
+	// Fields limits the _source fields fetched, * for full documents
+	Fields string `yaml:"fields"`
+	// Dedup skips rows already written, keyed on DedupField or the email and password pair
+	Dedup      bool   `yaml:"dedup"`
+	DedupField string `yaml:"dedup_field"`
+	// After and Before limit results to an RFC3339 date range on DateField
+	After     string `yaml:"after"`
+	Before    string `yaml:"before"`
+	DateField string `yaml:"date_field"`
+	// Split rolls over to a new numbered outfile every Split rows
+	Split int `yaml:"split"`
+	// Checkpoint is a file recording the progress of the export to continue it after a failure
+	Checkpoint string `yaml:"checkpoint"`
+	// Workers is the number of sliced scrolls run concurrently
+	Workers int `yaml:"workers"`
+	// ScrollSize and ScrollKeepAlive set the batch size and lifetime of scroll contexts
+	ScrollSize      int           `yaml:"scroll_size"`
+	ScrollKeepAlive time.Duration `yaml:"scroll_keepalive"`
+	// Rate caps the batches fetched per second across all workers, 0 is unlimited
+	Rate           float64 `yaml:"rate"`
+	SamplePerIndex int     `yaml:"sample_per_index"`
+	// reindex matches into another index instead of writing a file
+	ReindexTo string `yaml:"reindex_to"`
+	// SQLite inserts matches into a SQLite database instead of writing a file
+	SQLite             string `yaml:"sqlite"`
+	ReindexURL         string `yaml:"reindex_url"`
+	ReindexBreachField string `yaml:"reindex_breach_field"`
+	// Sort orders results by a field so exports are deterministic
+	Sort string `yaml:"sort"`
+	// SortBy buffers the rows and writes them ordered by this output column
+	SortBy string `yaml:"sort_by"`
+	// ResumeFromLine skips the rows already in a previous sorted export and appends the rest
+	ResumeFromLine int `yaml:"resume_from_line"`
+	// Cluster names the source cluster in a cluster column when merging results from several clusters
+	Cluster string `yaml:"-"`
+	// SearchTerm adds a search_term column when searching the terms of an input file
+	SearchTerm string `yaml:"-"`
+	// Append writes to the end of the outfile, with a header only if it is empty
+	Append bool `yaml:"append"`
+	// Force replaces an existing non-empty outfile
+	Force bool `yaml:"force"`
+	// EnrichOutfile receives the optional leak fields of every row, keeping
+	// the outfile to the core columns
+	EnrichOutfile string `yaml:"enrich_outfile"`
+	// local cache of finished exports
+	CacheDir string        `yaml:"cache_dir"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// SkipHealthCheck skips the cluster health query for accounts without
+	// cluster-level privileges
+	SkipHealthCheck bool `yaml:"skip_health_check"`
+	// Timeout bounds the whole run and every HTTP request, 0 disables it
+	Timeout time.Duration `yaml:"timeout"`
+	// Format of the outfile: csv, json, jsonl or hashcat
+	Format string `yaml:"format"`
+	// Delimiter separates CSV fields, a single character or tab
+	Delimiter string `yaml:"delimiter"`
+	// TLS settings for clusters with self-signed certificates
+	CACert   string `yaml:"ca_cert"`
+	Insecure bool   `yaml:"insecure"`
+	// Proxy is an http, https or socks5 proxy URL for the cluster connection
+	Proxy string `yaml:"proxy"`
+	// DumpRawResponse is a debug file receiving every raw response from the cluster
+	DumpRawResponse string `yaml:"dump_raw_response"`
+	// Encrypt writes the outfile as an age file protected by Passphrase, or
+	// readable by the Recipients parsed from EncryptTo when set
+	Encrypt    bool            `yaml:"encrypt"`
+	EncryptTo  string          `yaml:"encrypt_to"`
+	Passphrase string          `yaml:"-"`
+	Recipients []age.Recipient `yaml:"-"`
+	// Batch is the flag running several searches, clusters, input-file or
+	// jobs, set by run for validate
+	Batch string `yaml:"-"`
+	// Gzip compresses the outfile
+	Gzip bool `yaml:"gzip"`
 }
 
 // Leak definition from ElasticSearch JSON structure
-type Leak struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
+type Leak = hoardd.Leak
+
+// optional leak fields written as extra CSV columns when the index maps them
+var leakFields = hoardd.LeakFields
 
 // Response definition from ElasticSearch
 type Response struct {
@@ -65,263 +227,1537 @@ type Response struct {
 	Status       int
 }
 
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
+// rawResponseLogger receives the elastic trace log and keeps only the HTTP
+// responses, so request headers (and credentials) never reach the dump file
+type rawResponseLogger struct {
+	w io.Writer
+}
+
+func (l rawResponseLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if strings.HasPrefix(msg, "HTTP/") {
+		fmt.Fprintln(l.w, msg)
+	}
+}
+
+// stratified sampling bounds, top_hits is capped by index.max_inner_result_window
+const (
+	maxSamplePerIndex = 100
+	maxSampleIndices  = 1000
+)
+
+// sortedRow is a row held back by sort-by until the export finishes
+type sortedRow struct {
+	key  string
+	leak *Leak
+	hit  *elastic.SearchHit
+}
+
+// reindexDoc returns the document to bulk index for hit, adding the breach
+// name under breachField when one is configured
+func reindexDoc(hit *elastic.SearchHit, breachField string) (interface{}, error) {
+	if len(hit.Source) == 0 {
+		return nil, errors.New("no _source")
+	} else if breachField == "" {
+		return hit.Source, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(hit.Source, &doc); err != nil {
+		return nil, err
+	}
+	doc[breachField] = hoardd.BreachName(hit.Index)
+	return doc, nil
+}
+
+// closeReindex flushes outstanding bulk requests and reports the totals
+func closeReindex(bulk *elastic.BulkProcessor, target string) error {
+	if err := bulk.Close(); err != nil {
+		return err
+	}
+	stats := bulk.Stats()
+	infof("Reindexed %d documents into %s (%d failed)", stats.Succeeded, target, stats.Failed)
+	return nil
+}
+
+// fieldEncodings maps output field names to the encoding applied on output
+type fieldEncodings map[string]string
+
+// encodable output fields and supported encodings for -encode-fields
+var (
+	encodableFields = append([]string{"email", "password"}, leakFields...)
+	fieldEncoders   = map[string]func(string) string{
+		"base64": func(v string) string { return base64.StdEncoding.EncodeToString([]byte(v)) },
+		"hex":    func(v string) string { return hex.EncodeToString([]byte(v)) },
+	}
+)
+
+// parseFieldEncodings parses a field[=encoding] list, base64 is the default
+func parseFieldEncodings(spec string) (fieldEncodings, error) {
+	encodings := make(fieldEncodings)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		field, encoding := item, "base64"
+		if i := strings.Index(item, "="); i >= 0 {
+			field, encoding = item[:i], item[i+1:]
+		}
+		if !isLeakField(field) {
+			return nil, fmt.Errorf("unknown field %q, must be one of %s", field, strings.Join(encodableFields, ", "))
+		}
+		if _, ok := fieldEncoders[encoding]; !ok {
+			return nil, fmt.Errorf("unknown encoding %q for field %s, must be base64 or hex", encoding, field)
+		}
+		encodings[field] = encoding
+	}
+	return encodings, nil
+}
+
+// apply encodes value if field has an encoding configured
+func (e fieldEncodings) apply(field, value string) string {
+	if encoding, ok := e[field]; ok {
+		return fieldEncoders[encoding](value)
+	}
+	return value
+}
+
+func (e fieldEncodings) String() string {
+	var items []string
+	for field, encoding := range e {
+		items = append(items, field+"="+encoding)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
-	})
-	return found
+	}
+	return false
+}
+
+// isLeakField reports whether name is a field of Leak
+func isLeakField(name string) bool {
+	for _, field := range encodableFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// maskPassword hides a password behind its first and last character and its
+// length, i.e. p****d (6)
+func maskPassword(password string) string {
+	runes := []rune(password)
+	if len(runes) <= 2 {
+		return fmt.Sprintf("%s (%d)", strings.Repeat("*", len(runes)), len(runes))
+	}
+	return fmt.Sprintf("%c%s%c (%d)", runes[0], strings.Repeat("*", len(runes)-2), runes[len(runes)-1], len(runes))
+}
+
+// leadingWildcard matches query strings whose term starts with a wildcard
+var leadingWildcard = regexp.MustCompile(`:\s*"?[*?]`)
+
+// warnSlowQuery explains why a step of the query exceeded the budget
+func warnSlowQuery(step string, took, budget time.Duration, queryString string) {
+	if budget <= 0 || took <= budget {
+		return
+	}
+	warnf("%s took %s, over the max-query-time budget of %s", step, took.Round(time.Millisecond), budget)
+	if leadingWildcard.MatchString(queryString) {
+		warnf("this query is slow because of a leading wildcard in %s, which scans every "+
+			"indexed term - narrow -index to specific breaches to speed it up", queryString)
+	} else {
+		warnf("narrow -index to specific breaches or set a -limit to speed this query up")
+	}
+}
+
+// output formats
+var outputFormats = map[string]bool{"csv": true, "json": true, "jsonl": true, "hashcat": true}
+
+// parseDelimiter returns the CSV field separator named by s, a comma when empty
+func parseDelimiter(s string) (rune, error) {
+	if s == "" {
+		return ',', nil
+	} else if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError {
+		return 0, errors.New("delimiter must be a single character or tab")
+	} else if r == '"' || r == '\r' || r == '\n' {
+		return 0, errors.New("delimiter cannot be a quote or line break")
+	}
+	return r, nil
+}
+
+// rowFormat renders leaks as rows of the output format
+type rowFormat struct {
+	// format is csv, json, jsonl or hashcat
+	format string
+	// delimiter separates CSV fields
+	delimiter  rune
+	encodings  fieldEncodings
+	rawIndex   bool
+	noPassword bool
+	// maskPasswords writes masked passwords
+	maskPasswords bool
+	// highlightField adds its highlighted fragments as a matched_context column
+	highlightField string
+	// fields are the optional leak fields written as extra CSV columns
+	fields []string
+	// cluster adds a cluster column naming the source cluster
+	cluster string
+	// searchTerm adds a search_term column naming the input file term
+	searchTerm string
+	// domains of a multi-domain search, the one matching each hit is its search_term
+	domains []string
+	// passwords marks a search of password candidates, the password of each
+	// hit is the candidate it matched and its search_term
+	passwords bool
+	// layout selects, orders and renames the CSV columns when set
+	layout []outputColumn
+	// meta is embedded ahead of the results when set
+	meta *exportMeta
+	// hitMeta adds _id, _index and _score columns
+	hitMeta bool
+	// rowNumbers starts every row with its number, the key of the side file
+	// written by -enrich-outfile
+	rowNumbers bool
+	// hashField and saltField are the fields written by hashcat output
+	hashField string
+	saltField string
+	// hashcatUser starts hashcat lines with the email
+	hashcatUser bool
+	// nullValues are junk values counting as empty, hashcat output skips
+	// hashes and salts holding one
+	nullValues []string
+	// number of rows rendered, json needs it to separate array elements
+	n int
+}
+
+// exportMeta describes the query behind an export, embedded by -embed-query
+type exportMeta struct {
+	Query     string `json:"query"`
+	Index     string `json:"index"`
+	Generated string `json:"generated"`
+	Total     int64  `json:"total"`
+	Version   string `json:"version"`
+}
+
+// comments renders the metadata as CSV comment lines
+func (m *exportMeta) comments() string {
+	// a line break in a search term would end the comment early
+	query := strings.NewReplacer("\r", " ", "\n", " ").Replace(m.Query)
+	return fmt.Sprintf("# query: %s\n# index: %s\n# generated: %s\n# total: %d\n# version: %s\n",
+		query, m.Index, m.Generated, m.Total, m.Version)
+}
+
+// header starts the output, a header line for CSV or the opening bracket of a
+// JSON array, after the metadata if any
+func (r *rowFormat) header() string {
+	switch r.format {
+	case "json":
+		if r.meta != nil {
+			// the metadata only holds strings and numbers, which always encode
+			meta, _ := json.Marshal(r.meta)
+			return `{"meta":` + string(meta) + `,"results":[` + "\n"
+		}
+		return "[\n"
+	case "jsonl", "hashcat":
+		return ""
+	}
+	if r.meta != nil {
+		return r.meta.comments() + r.csvLine(r.headers())
+	}
+	return r.csvLine(r.headers())
+}
+
+// headers returns the header names of the CSV columns
+func (r *rowFormat) headers() []string {
+	if r.layout == nil {
+		return r.columns()
+	}
+	headers := make([]string, len(r.layout))
+	for i, column := range r.layout {
+		headers[i] = column.header
+	}
+	return headers
+}
+
+// columns returns the names of the CSV columns
+func (r *rowFormat) columns() []string {
+	if r.layout != nil {
+		names := make([]string, len(r.layout))
+		for i, column := range r.layout {
+			names[i] = column.name
+		}
+		return names
+	}
+	header := []string{"email", "password", "breach_name"}
+	if r.noPassword {
+		header = []string{"email", "breach_name"}
+	}
+	if r.rowNumbers {
+		header = append([]string{"row"}, header...)
+	}
+	header = append(header, r.fields...)
+	if r.rawIndex {
+		header = append(header, "raw_index")
+	}
+	if r.highlightField != "" {
+		header = append(header, "matched_context")
+	}
+	if r.cluster != "" {
+		header = append(header, "cluster")
+	}
+	if r.searchTerm != "" || len(r.domains) > 0 || r.passwords {
+		header = append(header, "search_term")
+	}
+	if r.hitMeta {
+		header = append(header, hitMetaColumns...)
+	}
+	return header
+}
+
+// footer ends the output, closing the array for JSON
+func (r *rowFormat) footer() string {
+	if r.format != "json" {
+		return ""
+	}
+	end := "]\n"
+	if r.meta != nil {
+		end = "]}\n"
+	}
+	if r.n == 0 {
+		return end
+	}
+	return "\n" + end
+}
+
+func (r *rowFormat) row(l *Leak, hit *elastic.SearchHit) string {
+	if r.format == "json" || r.format == "jsonl" {
+		return r.record(l, hit)
+	} else if r.format == "hashcat" {
+		return r.hashcatLine(l, hit)
+	}
+	r.n++
+	return r.csvLine(r.values(l, hit))
+}
+
+// values returns the CSV column values of a leak
+func (r *rowFormat) values(l *Leak, hit *elastic.SearchHit) []string {
+	columns := r.columns()
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		row[i] = r.value(column, l, hit)
+	}
+	return row
+}
+
+// value returns the value of a single CSV column
+func (r *rowFormat) value(column string, l *Leak, hit *elastic.SearchHit) string {
+	switch column {
+	case "email":
+		return r.encodings.apply("email", l.Email)
+	case "password":
+		return r.encodings.apply("password", r.password(l))
+	case "breach_name":
+		return hoardd.BreachName(hit.Index)
+	case "raw_index":
+		return hit.Index
+	case "matched_context":
+		return strings.Join(hit.Highlight[r.highlightField], " ... ")
+	case "cluster":
+		return r.cluster
+	case "search_term":
+		return r.term(l)
+	case "_id":
+		return hit.Id
+	case "_index":
+		return hit.Index
+	case "_score":
+		return hitScore(hit)
+	case "row":
+		return strconv.Itoa(r.n)
+	}
+	return r.encodings.apply(column, l.Field(column))
+}
+
+// csvLine encodes fields as a CSV line, quoting fields that contain the
+// delimiter, quotes or line breaks
+func (r *rowFormat) csvLine(fields []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if r.delimiter != 0 {
+		w.Comma = r.delimiter
+	}
+	// the delimiter is validated up front, so writing to memory cannot fail
+	w.Write(fields)
+	w.Flush()
+	return b.String()
+}
+
+// term returns the search term a leak matched
+func (r *rowFormat) term(l *Leak) string {
+	if r.passwords {
+		return l.Password
+	}
+	email := strings.ToLower(l.Email)
+	for _, domain := range r.domains {
+		if strings.HasSuffix(email, "@"+strings.ToLower(domain)) {
+			return domain
+		}
+	}
+	return r.searchTerm
+}
+
+// password returns the password as written, masked if configured
+func (r *rowFormat) password(l *Leak) string {
+	if r.maskPasswords {
+		return maskPassword(l.Password)
+	}
+	return l.Password
+}
+
+// record renders a leak as a JSON object, the full document with the email
+// and password as guarded by max-field-length plus the derived columns
+func (r *rowFormat) record(l *Leak, hit *elastic.SearchHit) string {
+	record := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+	// keep numbers exactly as indexed
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil || record == nil {
+		record = make(map[string]interface{})
+	}
+	record["email"] = l.Email
+	if r.noPassword {
+		delete(record, "password")
+	} else {
+		record["password"] = r.password(l)
+	}
+	for _, field := range encodableFields {
+		if _, ok := r.encodings[field]; !ok {
+			continue
+		}
+		if value, ok := record[field].(string); ok {
+			record[field] = r.encodings.apply(field, value)
+		}
+	}
+	record["breach_name"] = hoardd.BreachName(hit.Index)
+	if r.rawIndex {
+		record["raw_index"] = hit.Index
+	}
+	if r.highlightField != "" {
+		record["matched_context"] = strings.Join(hit.Highlight[r.highlightField], " ... ")
+	}
+	if r.cluster != "" {
+		record["cluster"] = r.cluster
+	}
+	if r.searchTerm != "" || len(r.domains) > 0 || r.passwords {
+		record["search_term"] = r.term(l)
+	}
+	if r.hitMeta {
+		record["_id"] = hit.Id
+		record["_index"] = hit.Index
+		// null when the hits are sorted and not scored
+		record["_score"] = hit.Score
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		// decoded JSON always marshals
+		panic(err)
+	}
+	r.n++
+	if r.format == "jsonl" {
+		return string(data) + "\n"
+	} else if r.n > 1 {
+		return ",\n" + string(data)
+	}
+	return string(data)
+}
+
+// versionWarning describes known compatibility issues of the client with the
+// given Elasticsearch version, if any
+func versionWarning(version string) string {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return ""
+	}
+	if major < 7 {
+		return fmt.Sprintf("Elasticsearch %s is older than 7.x and not supported by this client, expect query failures", version)
+	} else if major > 7 {
+		return fmt.Sprintf("Elasticsearch %s is newer than the 7.x API this client speaks, "+
+			"scroll and search requests may fail unless the cluster accepts 7.x compatible requests", version)
+	}
+	return ""
+}
+
+// limitField enforces the maximum field length on value, either truncating it
+// in place or reporting that the whole hit should be skipped
+func limitField(name string, value *string, max int, action string) bool {
+	if max <= 0 || len(*value) <= max {
+		return true
+	}
+	if action == "skip" {
+		warnf("%s field is %d bytes (max %d), skipping hit", name, len(*value), max)
+		return false
+	}
+	warnf("%s field is %d bytes (max %d), truncating", name, len(*value), max)
+	*value = (*value)[:max]
+	return true
 }
 
 func main() {
 	// logging settings
 	log.SetFlags(2)
-	// command-line args
+	err := run()
+	switch err {
+	case nil, errLimitReached:
+		return
+	case errNoResults:
+		errorf("0 results returned, check your query")
+	case errInterrupted:
+		errorf("Interrupted")
+	case errTimeout:
+		errorf("Timed out")
+	default:
+		errorf("%s", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// run parses the flags and config, then runs the requested searches. The
+// returned error selects the exit code.
+func run() error {
+	// command-line args, settings are bound to the config
+	cfg := defaultConfig()
+	bindFlags(flag.CommandLine, &cfg)
 	var (
-		flagConfig   = flag.String("config", "", "path to YAML config file")
-		flagInputURL = flag.String("url", "", "URL for ElasticsSearch endpoint")
-		flagIndex    = flag.String("index", "leak_*", "Elasticsearch index name i.e. leak_linkedin")
-		flagUsername = flag.String("username", "", "Elasticsearch username")
-		flagPassword = flag.String("password", "", "Elasticsearch password")
-		flagOutfile  = flag.String("outfile", "", "Output filename")
-		flagDomain   = flag.String("domain", "", "domain to search")
-		flagPass     = flag.String("pass", "", "password to search")
-		flagEmail    = flag.String("email", "", "email to search")
-		flagLimit    = flag.Int("limit", 0, "Maximum number of results to return (default 1,000,000) - set to 0 for no limit")
-		flagDebug    = flag.Bool("debug", false, "Enable or disable debug output")
-		flagVerbose  = flag.Bool("verbose", false, "Enable or disable verbose output")
+		flagConfig = flag.String("config", "", "path to YAML config file (default $HOARDD_CONFIG)")
+		flagJobs   = flag.String("jobs", "", "path to YAML file listing multiple searches to run in sequence")
+		// many terms in one run
+		flagInputFile = flag.String("input-file", "", "path to a file with one search term per line, results are appended to one outfile")
+		flagInputType = flag.String("input-type", "", "Search field of the input-file terms: domain, email, pass, ip, user, hash, or phone")
+		flagClusters  = flag.String("clusters", "", "path to YAML file listing multiple clusters to run the search against")
+		// cluster concurrency
+		flagClusterWorkers = flag.Int("cluster-workers", 2, "Number of clusters searched concurrently when using clusters")
+		flagVersion        = flag.Bool("version", false, "Print the version, git commit and build date and exit")
+		flagInteractive    = flag.Bool("interactive", false, "Connect once and read searches from stdin, printing counts and a sample of hits")
+		flagDescribe       = flag.Bool("describe", false, "Print the fields and types mapped in the indices matching index and exit")
+		flagCheck          = flag.Bool("check", false, "Write a term,exposed,breach_count report for the input-file terms instead of their results")
+		flagValidate       = flag.Bool("validate-config", false, "Check the config, flags and connection, including the credentials, then exit without searching")
 	)
 	flag.Parse()
-	var config = *flagConfig
-	var (
-		inputURL string
-		index    string
-		username string
-		password string
-		outfile  string
-		verbose  bool
-		debug    bool
-		limit    int
-		domain   string
-		email    string
-		pass     string
-	)
-	// todo : check for path
+	if *flagVersion {
+		fmt.Println(versionString())
+		return nil
+	}
+	// global YAML defaults, overridden by an explicit config and flags
+	var configs []string
+	if global := globalConfigPath(); global != "" {
+		if _, err := os.Stat(global); err == nil {
+			configs = append(configs, global)
+		}
+	}
 	// YAML args
-	if config != "" {
-		f, err := os.Open(config)
-		check(err)
-		defer f.Close()
-		var cfg Config
-		decoder := yaml.NewDecoder(f)
-		err = decoder.Decode(&cfg)
-		check(err)
-		if debug {
-			log.Printf("config dump: %+v", cfg)
-		}
-		inputURL = cfg.InputURL
-		index = cfg.Index
-		username = cfg.Username
-		password = cfg.Password
-		outfile = cfg.Outfile
-		verbose = cfg.Verbose
-		debug = cfg.Debug
-		limit = cfg.Limit
-		domain = cfg.Domain
-		email = cfg.Email
-		pass = cfg.Pass
-		f.Close()
+	path, err := configPath(*flagConfig)
+	if err != nil {
+		return usagef("Error loading config: %s", err)
+	} else if path != "" {
+		configs = append(configs, path)
 	}
-	// check for empty args
-	// todo create loop through vars
-	if isFlagPassed("url") {
-		inputURL = *flagInputURL
+	if err := mergeConfig(flag.CommandLine, &cfg, configs...); err != nil {
+		return usagef("Error loading config: %s", err)
 	}
-	if isFlagPassed("index") {
-		index = *flagIndex
+	// verbose and debug predate the log levels and enable debug messages
+	level := cfg.LogLevel
+	if level == "" {
+		level = "info"
+		if cfg.Verbose || cfg.Debug {
+			level = "debug"
+		}
 	}
-	if isFlagPassed("username") {
-		username = *flagUsername
+	if minLevel, err = parseLogLevel(level); err != nil {
+		return usagef("Error parsing log-level parameter: %s", err)
 	}
+	debugf("%s", versionString())
 	if isFlagPassed("password") {
-		password = *flagPassword
-	}
-	if isFlagPassed("outfile") {
-		outfile = *flagOutfile
+		warnf("-password is visible in shell history and process listings, set %s instead", passwordEnv)
+	} else if password := os.Getenv(passwordEnv); password != "" {
+		cfg.Password = password
 	}
-	if isFlagPassed("verbose") {
-		verbose = *flagVerbose
+	if cfg.Debug {
+		dump := cfg
+		if dump.Password != "" {
+			dump.Password = "<redacted>"
+		}
+		debugf("config dump: %+v", dump)
 	}
-	if isFlagPassed("debug") {
-		debug = *flagDebug
+	// recipients replace the passphrase, encrypt-to otherwise works like encrypt
+	if cfg.EncryptTo != "" && cfg.Encrypt {
+		return usageError("encrypt and encrypt-to are mutually exclusive")
 	}
-	if isFlagPassed("limit") {
-		limit = *flagLimit
+	cfg.Encrypt = cfg.Encrypt || cfg.EncryptTo != ""
+	if *flagInteractive && (*flagJobs != "" || *flagInputFile != "" || *flagClusters != "") {
+		return usageError("interactive cannot be combined with jobs, input-file, or clusters")
+	} else if *flagDescribe && (*flagInteractive || *flagJobs != "" || *flagInputFile != "" || *flagClusters != "") {
+		return usageError("describe cannot be combined with interactive, jobs, input-file, or clusters")
+	} else if *flagCheck && *flagInputFile == "" {
+		return usageError("check requires input-file, it reports the exposure of every term")
+	} else if *flagValidate && (*flagInteractive || *flagDescribe) {
+		return usageError("validate-config cannot be combined with interactive or describe")
 	}
-	if isFlagPassed("domain") {
-		domain = *flagDomain
+	// multiple clusters bring their own connection details
+	var clusters []Cluster
+	if *flagClusters != "" {
+		if *flagJobs != "" || *flagInputFile != "" {
+			return usageError("jobs, input-file, and clusters parameters are mutually exclusive")
+		} else if *flagClusterWorkers < 1 {
+			return usageError("cluster-workers must be at least 1")
+		} else if cfg.ReindexTo != "" || cfg.SQLite != "" || cfg.Append {
+			return usageError("reindex-to, sqlite and append cannot be combined with clusters")
+		}
+		var err error
+		cfg.Batch = "clusters"
+		clusters, err = loadClusters(*flagClusters)
+		if err != nil {
+			return usagef("Error loading clusters file: %s", err)
+		}
+		if cfg.Outfile == "" {
+			cfg.Outfile = autoOutfile(cfg)
+			warnf("no outfile specified, automatically generating one: %s", cfg.Outfile)
+		}
+		if err := checkOverwrite(cfg.Outfile, cfg.Force); err != nil {
+			return err
+		}
 	}
-	if isFlagPassed("email") {
-		email = *flagEmail
+	// batch jobs bring their own search terms and outfiles
+	var jobs []Job
+	var terms []string
+	termWorkers := 1
+	if *flagJobs != "" {
+		var err error
+		cfg.Batch = "jobs"
+		jobs, err = loadJobs(*flagJobs)
+		if err != nil {
+			return usagef("Error loading jobs file: %s", err)
+		}
+		if set := setSearchParams(cfg); len(set) > 0 {
+			return usagef("%s cannot be combined with jobs, the search parameters are set per job", strings.Join(set, " and "))
+		} else if cfg.Outfile != "" {
+			return usageError("outfile cannot be combined with jobs, it is set per job")
+		} else if *flagInputFile != "" {
+			return usageError("jobs, input-file, and clusters parameters are mutually exclusive")
+		}
+	} else if *flagInputFile != "" {
+		// the input type selects the field every term is searched in
+		if !inputTypes[*flagInputType] {
+			return usageError("input-type must be one of domain, email, pass, ip, user, hash, or phone when using input-file")
+		} else if set := setSearchParams(cfg); len(set) > 0 {
+			return usagef("%s cannot be combined with input-file, the search terms come from the input file", strings.Join(set, " and "))
+		} else if cfg.Format == "json" || cfg.Encrypt {
+			// every term appends to the outfile
+			return usageError("input-file cannot be combined with json format or encrypt, use jsonl instead")
+		} else if *flagCheck && (cfg.Format != "csv" || cfg.Gzip || cfg.Append || cfg.SQLite != "" || cfg.ReindexTo != "" ||
+			cfg.CountOnly || cfg.Summary || cfg.ListFields || cfg.Sample > 0) {
+			return usageError("check writes a csv report and cannot be combined with json formats, gzip, append, sqlite, reindex-to, " +
+				"count-only, summary, list-fields or sample")
+		} else if cfg.Workers > 1 && cfg.SQLite != "" {
+			return usageError("sqlite cannot be combined with workers for input-file, the database takes one writer at a time")
+		}
+		// the workers search terms concurrently instead of slicing each search
+		if cfg.Workers > 1 {
+			termWorkers, cfg.Workers = cfg.Workers, 1
+		}
+		var err error
+		cfg.Batch = "input-file"
+		terms, err = loadSearchTerms(*flagInputFile)
+		if err != nil {
+			return usagef("Error loading input file: %s", err)
+		}
+		if cfg.Outfile == "" && cfg.ReindexTo == "" && cfg.SQLite == "" && !cfg.CountOnly && !cfg.Summary && cfg.Sample == 0 {
+			cfg.Outfile = autoOutfile(cfg)
+			warnf("no outfile specified, automatically generating one: %s", cfg.Outfile)
+		}
+		if cfg.Outfile != "" && !cfg.Append {
+			if err := checkOverwrite(cfg.Outfile, cfg.Force); err != nil {
+				return err
+			}
+		}
+	} else if *flagDescribe {
+		// only the mappings are read, nothing is searched
+		if set := setSearchParams(cfg); len(set) > 0 {
+			return usagef("%s cannot be combined with describe, which lists the fields of the index", strings.Join(set, " and "))
+		}
+	} else if *flagInteractive {
+		// the searches are read from stdin and printed to stdout
+		if set := setSearchParams(cfg); len(set) > 0 {
+			return usagef("%s cannot be combined with interactive, the searches are read from stdin", strings.Join(set, " and "))
+		} else if cfg.Outfile != "" || cfg.ReindexTo != "" || cfg.SQLite != "" {
+			return usageError("outfile, reindex-to, and sqlite cannot be combined with interactive, results are printed")
+		}
+	} else if *flagValidate && len(setSearchParams(cfg)) == 0 {
+		// a config holding only connection settings is complete without a search
+	} else if err := validateSearch(cfg); err != nil {
+		// a single search needs exactly one search parameter
+		return err
 	}
-	if isFlagPassed("pass") {
-		pass = *flagPass
+	// the range filters the searches of every mode, checked before connecting
+	if cfg.CIDR != "" {
+		if _, _, err := net.ParseCIDR(cfg.CIDR); err != nil {
+			return usagef("Error parsing cidr parameter: %s", err)
+		}
 	}
-	// check for overlapping arguments
-	argCount := 0
-	if domain != "" {
-		argCount++
+	if cfg.PassFile != "" {
+		if cfg.Passes, err = loadPasswords(cfg.PassFile); err != nil {
+			return usagef("Error loading pass file: %s", err)
+		} else if cfg.NoPasswordOutput || cfg.MaskPasswords {
+			return usageError("pass-file cannot be combined with no-password-output or mask-passwords, the matched password is written as search_term")
+		}
 	}
-	if email != "" {
-		argCount++
+	if cfg.QueryFile != "" {
+		if cfg.RawQuery, err = loadQueryFile(cfg.QueryFile); err != nil {
+			return usagef("Error loading query file: %s", err)
+		} else if cfg.Highlight {
+			return usageError("highlight cannot be combined with query-file, the field a raw query matches is unknown")
+		}
 	}
-	if pass != "" {
-		argCount++
+	if cfg.Username != "" && cfg.Password == "" && clusters == nil {
+		password, ok, err := promptPassword(cfg.Username)
+		if err != nil {
+			return usagef("Error reading password: %s", err)
+		} else if ok {
+			cfg.Password = password
+		}
 	}
-	if argCount == 0 {
-		log.Fatal("an argument for one of the following parameters must be supplied: " +
-			"domain, email, or pass")
-	} else if argCount > 1 {
-		log.Fatal("domain, email, and pass parameters are mutually exclusive, i.e. " +
-			"only one can receive a value")
+	// Elastic Cloud deployments are addressed by their cloud ID instead
+	if cfg.CloudID != "" {
+		if cfg.InputURL != "" {
+			return usageError("url and cloud-id are mutually exclusive, remove one from the flags and config files")
+		} else if clusters != nil {
+			return usageError("cloud-id cannot be combined with clusters, set the url per cluster")
+		}
+		endpoint, err := cloudURL(cfg.CloudID)
+		if err != nil {
+			return usagef("Error parsing cloud-id parameter: %s", err)
+		}
+		cfg.InputURL = endpoint
+		debugf("connecting to Elastic Cloud endpoint %s", endpoint)
 	}
 	// check for missing arguments
-	if inputURL == "" {
+	if clusters != nil {
+		// connection details come from the clusters file
+	} else if cfg.InputURL == "" {
 		flag.PrintDefaults()
-		log.Fatal("Missing required url parameter, exiting")
-	} else if index == "" {
+		return usageError("Missing required url or cloud-id parameter, exiting")
+	} else if cfg.Index == "" {
 		flag.PrintDefaults()
-		log.Fatal("Missing required index parameter, exiting")
-	} else if username == "" {
+		return usageError("Missing required index parameter, exiting")
+	} else if cfg.Username == "" {
 		flag.PrintDefaults()
-		log.Fatal("Missing required username parameter, exiting")
-	} else if password == "" {
+		return usageError("Missing required username parameter, exiting")
+	} else if cfg.Password == "" {
 		flag.PrintDefaults()
-		log.Fatal("Missing required password parameter, exiting")
-	} else if limit == 0 {
-		log.Printf("warning: no limit defined, this might take a LONG time")
+		return usageError("Missing required password parameter, exiting")
+	} else if cfg.Limit == 0 {
+		warnf("no limit defined, this might take a LONG time")
+	}
+	if err := validate(cfg); err != nil {
+		return err
+	}
+	if encodings, _ := parseFieldEncodings(cfg.EncodeFields); len(encodings) > 0 {
+		debugf("encoded fields: %s", encodings)
+	}
+	if cfg.Insecure {
+		warnf("TLS certificate verification is disabled, the connection is open to interception")
+	}
+	if cfg.EncryptTo != "" {
+		if cfg.Recipients, err = parseRecipients(cfg.EncryptTo); err != nil {
+			return usagef("Error parsing encrypt-to parameter: %s", err)
+		}
+	} else if cfg.Encrypt && !*flagValidate {
+		// nothing is written when validating, so there is nothing to encrypt
+		cfg.Passphrase, err = readPassphrase()
+		if err != nil {
+			return usagef("Error reading passphrase: %s", err)
+		}
 	}
 
-	// validate args
-	_, err := url.ParseRequestURI(inputURL)
-	if err != nil {
-		log.Fatalf("Error parsing url parameter: %s", inputURL)
+	// raw response dump for debugging
+	if cfg.DumpRawResponse != "" {
+		if !cfg.Debug {
+			warnf("dump-raw-response requires debug, ignoring")
+		} else {
+			dump, err := os.Create(cfg.DumpRawResponse)
+			if err != nil {
+				return err
+			}
+			defer dump.Close()
+			clientOptions = append(clientOptions, elastic.SetTraceLog(rawResponseLogger{w: dump}))
+			infof("dumping raw responses to %s", cfg.DumpRawResponse)
+		}
+	}
+
+	// Ctrl-C cancels the search, see export for what is kept
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func(ctx context.Context) {
+		// a second Ctrl-C exits immediately
+		<-ctx.Done()
+		infof("interrupted, stopping the search (press Ctrl-C again to exit immediately)")
+		stop()
+	}(ctx)
+	// the timeout cancels the search like Ctrl-C, but keeps partial results
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	if clusters != nil && *flagValidate {
+		if failed := validateClusters(ctx, cfg, clusters); failed > 0 {
+			return fmt.Errorf("%d of %d clusters failed the check", failed, len(clusters))
+		}
+		infof("config ok")
+		return nil
+	}
+	if clusters != nil {
+		failed, err := runClusters(ctx, cfg, clusters, *flagClusterWorkers)
+		if ctx.Err() != nil {
+			return stopError(ctx)
+		} else if failed > 0 {
+			// exits with the code of the worst failure
+			return fmt.Errorf("%d of %d clusters failed: %w", failed, len(clusters), err)
+		}
+		infof("Done")
+		return nil
 	}
 
 	//create client with retry
+	client, err := connect(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	if err := preflight(ctx, client, &cfg); err != nil {
+		return err
+	}
+	if *flagValidate {
+		infof("config ok, connected to %s as %s, searching %s", cfg.InputURL, cfg.Username, cfg.Index)
+		return nil
+	}
+
+	if jobs != nil {
+		failed := runJobs(ctx, client, cfg, jobs)
+		if ctx.Err() != nil {
+			return stopError(ctx)
+		} else if failed > 0 {
+			return fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+		}
+		infof("Done")
+		return nil
+	}
+	if *flagDescribe {
+		return describeIndex(ctx, client, cfg.Index, os.Stdout)
+	}
+	if *flagInteractive {
+		return runInteractive(ctx, client, cfg, os.Stdin, os.Stdout)
+	}
+	if terms != nil && *flagCheck {
+		failed := runCheck(ctx, client, cfg, *flagInputType, terms, termWorkers)
+		if ctx.Err() != nil {
+			return stopError(ctx)
+		} else if failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(terms))
+		}
+		infof("Done")
+		return nil
+	}
+	if terms != nil {
+		failed := runInputFile(ctx, client, cfg, *flagInputType, terms, termWorkers)
+		if ctx.Err() != nil {
+			return stopError(ctx)
+		} else if failed > 0 {
+			return fmt.Errorf("%d of %d searches failed", failed, len(terms))
+		}
+		infof("Done")
+		return nil
+	}
+	err = export(ctx, client, cfg)
+	if err == errLimitReached {
+		infof("Limit of %d results reached\n", cfg.Limit)
+		return nil
+	}
+	return err
+}
+
+// clientOptions are the client options shared by every connection, set by run
+// and reused when an export reconnects
+var clientOptions []elastic.ClientOptionFunc
+
+// connectRetryWait is the wait before the first connection retry, doubled
+// for every following attempt
+const connectRetryWait = 15 * time.Second
+
+// connect creates a client for the cluster in cfg, retrying on failure
+func connect(cfg Config, options ...elastic.ClientOptionFunc) (*elastic.Client, error) {
+	options = append([]elastic.ClientOptionFunc{
+		elastic.SetURL(cfg.InputURL),
+		elastic.SetSniff(false),
+		elastic.SetBasicAuth(cfg.Username, cfg.Password),
+	}, options...)
+	if cfg.CACert != "" || cfg.Insecure || cfg.Proxy != "" || cfg.Timeout > 0 {
+		httpClient, err := tlsHTTPClient(cfg.CACert, cfg.Insecure, cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		// a stalled request fails instead of hanging until the deadline
+		httpClient.Timeout = cfg.Timeout
+		options = append(options, elastic.SetHttpClient(httpClient))
+	}
 	var client *elastic.Client
-	check(err)
-	err = try.Do(func(attempt int) (bool, error) {
+	err := try.Do(func(attempt int) (bool, error) {
 		var err error
-		client, err = elastic.NewClient(elastic.SetURL(inputURL), elastic.SetSniff(false), elastic.SetBasicAuth(username, password))
-		if err != nil {
-			log.Printf("error connecting to elasticsearch: %s, retrying in 15s", err)
-			time.Sleep(15)
+		client, err = elastic.NewClient(options...)
+		if err != nil && attempt < 3 {
+			// exponential backoff, 15s then 30s
+			wait := connectRetryWait << uint(attempt-1)
+			warnf("error connecting to elasticsearch: %s, retrying in %s", err, wait)
+			time.Sleep(wait)
 		}
 		return attempt < 3, err // try 3 times
 	})
-	check(err)
-	// check cluster health
-	ctx := context.Background()
-	res, err := client.ClusterHealth().Index(index).Do(ctx)
-	check(err)
-	if verbose {
-		log.Printf("cluster health: %s", res.Status)
+	if err != nil {
+		return nil, &connectError{err}
 	}
+	return client, nil
+}
+
+// preflight checks the version, index, permissions and health of the cluster
+// before searching, narrowing cfg.Index to the readable indices and
+// cfg.ScrollSize to the result window when needed
+func preflight(ctx context.Context, client *elastic.Client, cfg *Config) error {
+	// detect the cluster version, this client speaks the Elasticsearch 7.x API
+	version, err := client.ElasticsearchVersion(cfg.InputURL)
+	if err != nil {
+		warnf("could not detect the Elasticsearch version: %s", err)
+	} else {
+		debugf("elasticsearch version: %s", version)
+		if warning := versionWarning(version); warning != "" {
+			warnf("%s", warning)
+		}
+	}
+	if err := checkIndex(ctx, client, cfg.Index); err != nil {
+		return err
+	}
+	// narrow to the readable indices when permissions only cover part of the index pattern
+	if _, err := client.Count(hoardd.SplitList(cfg.Index)...).Do(ctx); isSecurityException(err) {
+		readable, denied, err := readableIndices(ctx, client, cfg.Index)
+		if err != nil {
+			return err
+		}
+		if len(readable) == 0 {
+			return fmt.Errorf("credentials cannot search any index matching %s (denied: %s)", cfg.Index, strings.Join(denied, ", "))
+		}
+		warnf("no permission to search %s, searching only %s",
+			strings.Join(denied, ", "), strings.Join(readable, ", "))
+		cfg.Index = strings.Join(readable, ",")
+	}
+	// batches larger than the result window are rejected by the cluster
+	if window, index, err := maxResultWindow(ctx, client, cfg.Index); err != nil {
+		debugf("could not read index.max_result_window: %s", err)
+	} else if index != "" && cfg.ScrollSize > window {
+		warnf("scroll-size %d exceeds the index.max_result_window of %d on %s, capping it",
+			cfg.ScrollSize, window, index)
+		cfg.ScrollSize = window
+	}
+	// check cluster health, unless the account may not read it
+	if cfg.SkipHealthCheck {
+		infof("skipping the cluster health check, health was not verified")
+		return nil
+	}
+	res, err := client.ClusterHealth().Index(hoardd.SplitList(cfg.Index)...).Do(ctx)
+	if isSecurityException(err) {
+		return fmt.Errorf("no permission to read the cluster health, use -skip-health-check: %s", err)
+	} else if err != nil {
+		return err
+	}
+	debugf("cluster health: %s", res.Status)
 	if res.Status == "red" {
-		log.Fatal("Cluster Health is red, exiting. Contact Support.")
+		return errors.New("Cluster Health is red, exiting. Contact Support.")
 	}
-	// auto file output
-	if outfile == "" {
-		outfile = fmt.Sprintf("output_%d.csv", time.Now().Unix())
-		log.Printf("warning: no outfile specified, automatically generating one: %s", outfile)
+	return nil
+}
+
+// splitName numbers part n of a split outfile, i.e. output_2.csv.gz for output.csv.gz
+func splitName(outfile string, n int) string {
+	dir, base := filepath.Split(outfile)
+	ext := ""
+	// the extensions start at the first dot that doesn't start the name
+	if len(base) > 1 {
+		if i := strings.Index(base[1:], "."); i >= 0 {
+			base, ext = base[:i+1], base[i+1:]
+		}
 	}
+	return fmt.Sprintf("%s%s_%d%s", dir, base, n, ext)
+}
 
-	// check path exists/file create permissions
-	f, err := os.Create(outfile)
-	check(err)
-	defer f.Close()
-	// query definition
-	searchQuery := elastic.NewBoolQuery()
-	var queryString string
-
-	if email != "" {
-		queryString = fmt.Sprintf(`email:"%v"`, email)
-	} else if domain != "" {
-		queryString = fmt.Sprintf(`email:"*@%v"`, domain)
-	} else if pass != "" {
-		queryString = fmt.Sprintf(`password:"%v"`, pass)
-	} else {
-		log.Fatal("email, domain, or pass parameter must be supplied")
+// createOutput creates a temporary file next to name, so that an export only
+// appears under name once commitOutput renames it into place
+func createOutput(name string) (*os.File, error) {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// TempFile makes files readable by the owner only, unlike os.Create
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkOverwrite refuses to replace name when it holds earlier results,
+// unless force is set
+func checkOverwrite(name string, force bool) error {
+	if force {
+		return nil
+	}
+	if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+		return usagef("outfile %s already exists, set force to overwrite it or append to add to it", name)
+	}
+	return nil
+}
+
+// commitOutput closes the temporary file f and renames it to name
+func commitOutput(f *os.File, name string) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// autoOutfile names an outfile after the current time, with extensions for
+// the format, compression and encryption
+func autoOutfile(cfg Config) string {
+	ext := cfg.Format
+	if delimiter, _ := parseDelimiter(cfg.Delimiter); delimiter == '\t' {
+		ext = "tsv"
+	}
+	outfile := fmt.Sprintf("output_%d.%s", time.Now().Unix(), ext)
+	if cfg.Gzip {
+		outfile += ".gz"
+	}
+	if cfg.Encrypt {
+		outfile += ".age"
+	}
+	return outfile
+}
+
+// export errors with dedicated handling
+var (
+	errNoResults = errors.New("0 results returned, check your query")
+	// errLimitReached means the export completed up to the limit, not a failure
+	errLimitReached = errors.New("limit reached")
+	// errInterrupted means the export was cancelled, only output written in
+	// place is kept
+	errInterrupted = errors.New("interrupted")
+	// errTimeout means the timeout passed, the results received so far are kept
+	errTimeout = errors.New("timed out")
+)
+
+// stopError returns the error for a cancelled ctx, errTimeout once the
+// timeout passed and errInterrupted otherwise
+func stopError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errTimeout
+	}
+	return errInterrupted
+}
+
+// export runs the search described by cfg and writes the results to its
+// outfile, or to its reindex target
+func export(ctx context.Context, client *elastic.Client, cfg Config) error {
+	encodings, err := parseFieldEncodings(cfg.EncodeFields)
+	if err != nil {
+		return err
+	}
+	delimiter, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return err
+	}
+	var layout []outputColumn
+	if cfg.Columns != "" {
+		if layout, err = parseColumns(cfg.Columns); err != nil {
+			return err
+		}
 	}
+	rows := rowFormat{
+		layout:        layout,
+		format:        cfg.Format,
+		delimiter:     delimiter,
+		encodings:     encodings,
+		rawIndex:      cfg.IncludeRawIndex,
+		hitMeta:       cfg.IncludeMeta,
+		noPassword:    cfg.NoPasswordOutput,
+		maskPasswords: cfg.MaskPasswords,
+		cluster:       cfg.Cluster,
+		searchTerm:    cfg.SearchTerm,
+		passwords:     len(cfg.Passes) > 0,
+		hashField:     cfg.HashField,
+		saltField:     cfg.SaltField,
+		hashcatUser:   cfg.HashcatUser,
+		nullValues:    hoardd.SplitList(cfg.NullValues),
+	}
+	// source filtering keeps excluded fields from ever leaving the cluster
+	var fetchSource *elastic.FetchSourceContext
+	if cfg.NoPasswordOutput {
+		fetchSource = elastic.NewFetchSourceContext(true).Exclude("password")
+	}
+	// queries are compiled and counted by the library, over a comma-separated
+	// list of indices, aliases and patterns
+	leakClient := hoardd.NewClient(client, cfg.Index)
+	leakClient.MinScore = cfg.MinScore
+	indices := leakClient.Indices()
 
-	searchQuery = searchQuery.Must(elastic.NewQueryStringQuery(queryString))
-	ss := elastic.NewSearchSource().Query(searchQuery)
+	// query definition
+	compiled, err := leakClient.Compile(ctx, buildQuery(cfg))
+	if err != nil {
+		return err
+	}
+	for _, warning := range compiled.Warnings {
+		warnf("%s", warning)
+	}
+	searchQuery, queryString, queryField := compiled.Query, compiled.String, compiled.Field
+	// the domain matching each hit of a multi-domain search is its search_term
+	rows.domains = compiled.Domains
+	var highlight *elastic.Highlight
+	if cfg.Highlight {
+		rows.highlightField = queryField
+		highlight = elastic.NewHighlight().Fields(elastic.NewHighlighterField(queryField))
+	}
+	// every scroll gets its own search source, scroll options modify it
+	searchSource := func() *elastic.SearchSource {
+		ss := elastic.NewSearchSource().Query(searchQuery)
+		if cfg.MinScore > 0 {
+			ss = ss.MinScore(cfg.MinScore)
+		}
+		if highlight != nil {
+			ss = ss.Highlight(highlight)
+		}
+		return ss
+	}
+	ss := searchSource()
 	source, err := ss.Source()
-	check(err)
+	if err != nil {
+		return err
+	}
 	data, err := json.Marshal(source)
-	check(err)
-	if verbose {
-		fmt.Printf("Raw Query: %s\n\n", string(data))
+	if err != nil {
+		return err
+	}
+	debugf("Raw Query: %s", string(data))
+
+	// counts apply the same relevance threshold as the export
+	count := func() (int64, error) {
+		return leakClient.CountCompiled(ctx, compiled)
+	}
+
+	// field discovery from a sample of matching documents
+	if cfg.ListFields {
+		search := client.Search(indices...).Query(searchQuery).Size(listFieldsSample)
+		if fetchSource != nil {
+			search = search.FetchSourceContext(fetchSource)
+		}
+		sample, err := search.Do(ctx)
+		if err != nil {
+			return err
+		}
+		var sources []json.RawMessage
+		for _, hit := range sample.Hits.Hits {
+			sources = append(sources, hit.Source)
+		}
+		if len(sources) == 0 {
+			return errNoResults
+		}
+		fields, err := sampleFields(sources)
+		if err != nil {
+			return err
+		}
+		printFields(os.Stdout, fields, len(sources))
+		return nil
+	}
+
+	// a quick look at the first hits, without setting up a scroll
+	if cfg.Sample > 0 {
+		search := client.Search(indices...).SearchSource(searchSource().Size(cfg.Sample))
+		if fetchSource != nil {
+			search = search.FetchSourceContext(fetchSource)
+		}
+		sample, err := search.Do(ctx)
+		if err != nil {
+			return err
+		}
+		if len(sample.Hits.Hits) == 0 {
+			return errNoResults
+		}
+		if cfg.SearchTerm != "" {
+			fmt.Printf("%s:\n", cfg.SearchTerm)
+		}
+		printSample(os.Stdout, sample.Hits.Hits, cfg.MaskPasswords)
+		return nil
+	}
+
+	// matches per breach, aggregated instead of scrolled
+	if cfg.Summary {
+		breachAgg := elastic.NewTermsAggregation().Field("_index").Size(maxSampleIndices)
+		src := elastic.NewSearchSource().Query(searchQuery).Size(0).Aggregation("breaches", breachAgg)
+		if cfg.MinScore > 0 {
+			src = src.MinScore(cfg.MinScore)
+		}
+		res, err := client.Search(indices...).SearchSource(src).Do(ctx)
+		if err != nil {
+			return err
+		}
+		breaches, ok := res.Aggregations.Terms("breaches")
+		if !ok || len(breaches.Buckets) == 0 {
+			return errNoResults
+		}
+		if cfg.SearchTerm != "" {
+			fmt.Printf("%s:\n", cfg.SearchTerm)
+		}
+		printSummary(os.Stdout, breachCounts(breaches), breaches.SumOfOtherDocCount)
+		return nil
+	}
+
+	// the same query as a full export, counted without fetching any hits
+	if cfg.CountOnly {
+		total, err := count()
+		if err != nil {
+			return err
+		}
+		if err := checkCount(cfg, total); err != nil {
+			return err
+		}
+		if cfg.SearchTerm != "" {
+			fmt.Printf("%s,%d\n", cfg.SearchTerm, total)
+		} else {
+			fmt.Printf("%d\n", total)
+		}
+		return nil
+	}
+
+	// extra CSV columns for the optional leak fields mapped by the index, merged
+	// cluster output always has all of them so the rows of every cluster line up
+	if cfg.Format == "csv" && cfg.ReindexTo == "" && layout != nil {
+		// a column layout fetches exactly the leak fields it writes
+		for _, column := range layout {
+			if containsString(leakFields, column.name) {
+				rows.fields = append(rows.fields, column.name)
+			}
+		}
+	} else if cfg.Format == "csv" && cfg.ReindexTo == "" {
+		if cfg.Cluster != "" {
+			rows.fields = leakFields
+		} else if rows.fields, err = mappedFields(ctx, client, cfg.Index, leakFields); err != nil {
+			warnf("could not detect the mapped leak fields, writing all of them: %s", err)
+			rows.fields = leakFields
+		}
+	}
+	// only the fields the output needs leave the cluster, explicit fields also
+	// select the extra CSV columns
+	fields := hoardd.SplitList(cfg.Fields)
+	if cfg.Fields != "*" && len(fields) > 0 {
+		var columns []string
+		for _, column := range rows.fields {
+			if containsString(fields, column) {
+				columns = append(columns, column)
+			}
+		}
+		rows.fields = columns
+	}
+	if cfg.Fields != "*" && cfg.ReindexTo == "" && (len(fields) > 0 || cfg.Format == "csv" || cfg.Format == "hashcat") {
+		includes := fields
+		if cfg.Format == "hashcat" {
+			includes = []string{cfg.HashField}
+			if cfg.SaltField != "" {
+				includes = append(includes, cfg.SaltField)
+			}
+		} else if len(includes) == 0 {
+			includes = append([]string{"email", "password"}, rows.fields...)
+		}
+		if cfg.DedupField != "" {
+			includes = append(includes, cfg.DedupField)
+		}
+		// rows without an email are dropped and dedup keys on it, so it is always needed
+		includes = append(includes, "email")
+		fetchSource = elastic.NewFetchSourceContext(true).Include(includes...)
+		if cfg.NoPasswordOutput {
+			fetchSource = fetchSource.Exclude("password")
+		}
+		debugf("fetching source fields: %s", strings.Join(includes, ", "))
+	}
+
+	// output setup: reindex target, sqlite database or outfile
+	o, err := openOutput(ctx, client, cfg, &rows, string(data))
+	if err != nil {
+		return err
+	} else if o == nil {
+		// served from the cache
+		return nil
+	}
+	defer o.close()
+	cp := o.cp
+
+	// stratified sample, the same number of random hits from every matching index
+	if cfg.SamplePerIndex > 0 {
+		randomQuery := elastic.NewFunctionScoreQuery().Query(searchQuery).AddScoreFunc(elastic.NewRandomFunction())
+		topHits := elastic.NewTopHitsAggregation().Size(cfg.SamplePerIndex)
+		if fetchSource != nil {
+			topHits = topHits.FetchSourceContext(fetchSource)
+		}
+		if highlight != nil {
+			topHits = topHits.Highlight(highlight)
+		}
+		breachAgg := elastic.NewTermsAggregation().Field("_index").Size(maxSampleIndices).
+			SubAggregation("sample", topHits)
+		sample, err := client.Search(indices...).Query(randomQuery).Size(0).Aggregation("breaches", breachAgg).Do(ctx)
+		if err != nil {
+			return err
+		}
+		breaches, ok := sample.Aggregations.Terms("breaches")
+		if !ok || len(breaches.Buckets) == 0 {
+			return errNoResults
+		}
+		var hits []*elastic.SearchHit
+		for _, bucket := range breaches.Buckets {
+			if topHits, ok := bucket.TopHits("sample"); ok {
+				hits = append(hits, topHits.Hits.Hits...)
+			}
+		}
+		sampled, err := o.writeHits(&rows, hits)
+		if err != nil {
+			return err
+		}
+		infof("Sampled %d results from %d breaches", sampled, len(breaches.Buckets))
+		return nil
+	}
+
+	// one confirming hit per email, collapsed on the cluster in a single page
+	if cfg.FirstOnly {
+		ss := searchSource().Collapse(elastic.NewCollapseBuilder("email")).Size(cfg.ScrollSize)
+		if cfg.Sort != "" {
+			ss = ss.SortBy(elastic.NewFieldSort(cfg.Sort).Asc())
+		}
+		search := client.Search(indices...).SearchSource(ss)
+		if fetchSource != nil {
+			search = search.FetchSourceContext(fetchSource)
+		}
+		res, err := search.Do(ctx)
+		if err != nil {
+			return err
+		} else if res.Hits == nil || len(res.Hits.Hits) == 0 {
+			return errNoResults
+		}
+		written, err := o.writeHits(&rows, res.Hits.Hits)
+		if err != nil {
+			return err
+		}
+		if len(res.Hits.Hits) == cfg.ScrollSize {
+			warnf("stopped at the first %d emails, the result window of a single page", cfg.ScrollSize)
+		}
+		infof("wrote the first result of %d emails to %s", written, o.dest())
+		return nil
 	}
 
 	//count results of query
-	total, err := client.Count(index).Query(searchQuery).Do(ctx)
-	check(err)
+	countStart := time.Now()
+	total, err := count()
+	if err != nil {
+		return err
+	}
+	debugf("Count Time: %+v", time.Since(countStart))
+	if cfg.EmbedQuery {
+		rows.meta = &exportMeta{
+			Query:     queryString,
+			Index:     cfg.Index,
+			Generated: time.Now().UTC().Format(time.RFC3339),
+			Total:     total,
+			Version:   version,
+		}
+	}
+	warnSlowQuery("count", time.Since(countStart), cfg.MaxQueryTime, queryString)
+	if err := checkCount(cfg, total); err != nil {
+		return err
+	}
 	if total == 0 {
-		log.Fatal("0 results returned, check your query")
+		return errNoResults
+	}
+	expected := progressTotal(total, cfg.Limit)
+	// json-log and progress lines replace the progress bar, which then only
+	// counts. The 64-bit total keeps counts above 2^31 intact on 32-bit builds.
+	bar := pb.New64(expected)
+	progressBar := !cfg.JSONLog && !cfg.NoProgress && term.IsTerminal(int(os.Stderr.Fd()))
+	if progressBar {
+		bar.Start()
 	}
-	bar := pb.StartNew(int(total))
-	scrollSize := 10000
-	scroll := client.Scroll()
-	q := scroll.KeepAlive("5m").Size(scrollSize).Query(searchQuery)
 	t0 := time.Now()
-	t1 := time.Now()
+	firstBatch := true
+	// status snapshots on demand
+	stats := newExportStats()
+	stopStatus := make(chan struct{})
+	if cfg.JSONLog {
+		// runs after the periodic events stopped
+		defer func() {
+			done := stats.event("done", bar.Current(), expected, t0)
+			done.Version = version
+			if o.db != nil {
+				done.Outfile = cfg.SQLite
+			} else if o.bulk == nil {
+				done.Outfile = o.outfile
+			}
+			writeEvent(os.Stderr, done)
+		}()
+		watchJSONProgress(os.Stderr, stats, bar.Current, expected, t0, stopStatus)
+	} else if !progressBar {
+		// redirected stderr and CI logs get plain lines without control characters
+		watchProgress(stats, bar.Current, expected, t0, stopStatus)
+	}
+	defer close(stopStatus)
+	watchStatus(stats, bar.Current, expected, t0, stopStatus)
+	ew, err := newExportWriter(cfg, &rows, o, stats, bar)
+	if err != nil {
+		return err
+	}
 
+	// page sourcing: sliced scrolls run concurrently, their pages are all
+	// written here
+	if cp != nil && cp.ScrollID != "" {
+		bar.SetCurrent(cp.Hits)
+	}
+	pages := newPageSource(ctx, client, cfg, indices, searchSource, fetchSource, cp)
+	defer pages.close()
+	// rows written by earlier runs of a checkpointed export
+	var checkpointRows int64
+	if cp != nil {
+		checkpointRows = cp.Rows
+	}
+	// throttled exports take every batch at the configured rate
+	var limiter *rate.Limiter
+	if cfg.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.Rate), 1)
+	}
+	// started here, after a checkpoint restored the hits of earlier runs
+	if minLevel == levelDebug {
+		watchThroughput(stats, bar.Current, expected, stopStatus)
+	}
 	for {
-		searchResult, err := q.Do(ctx)
-		actualTook := time.Now().Sub(t1)
+		if limiter != nil {
+			// a cancelled wait returns early, the batches then report the interrupt
+			limiter.Wait(ctx)
+		}
+		batch, ok := pages.next(ctx)
+		if !ok {
+			infof("Total time %+v\n", time.Now().Sub(t0))
+			break
+		}
+		searchResult, actualTook, err := batch.result, batch.took, batch.err
+		if firstBatch {
+			warnSlowQuery("first batch", actualTook, cfg.MaxQueryTime, queryString)
+			firstBatch = false
+		}
 		if err == nil {
-			w := bufio.NewWriter(f)
-			//print headers
-			_, err := w.WriteString(fmt.Sprintf("email,password,breach_name\n"))
-			check(err)
-			if verbose {
-				tookInMillis := searchResult.TookInMillis
-				log.Printf("Query Time: %+v and TookInMillis in response %+vms \n", actualTook, tookInMillis)
+			debugf("Query Time: %+v and TookInMillis in response %+vms", actualTook, searchResult.TookInMillis)
+			if err := ew.write(searchResult.Hits.Hits); err != nil {
+				return err
 			}
-			for _, hit := range searchResult.Hits.Hits {
-				var l *Leak
-				if debug {
-					fmt.Printf("Hit: %s\n", hit.Source)
-				}
-				err := json.Unmarshal(hit.Source, &l)
-				if err != nil {
-					panic(err)
-				}
-				// eliminate empty/null results
-				if len(l.Email) > 0 && l.Email != "null" {
-					_, err := w.WriteString(fmt.Sprintf("%s,%s,%s\n", l.Email, l.Password, strings.Replace(hit.Index, "leak_", "", 1)))
-					check(err)
+			if err := ew.flush(); err != nil {
+				return err
+			}
+			pages.advance(batch)
+			if cp != nil {
+				if err := saveCheckpoint(cfg.Checkpoint, cp, o.f, bar.Current(), checkpointRows+stats.count()); err != nil {
+					warnf("could not save checkpoint: %s", err)
 				}
-				w.Flush()
-				bar.Increment()
 			}
-			if limit != 0 && int(bar.Current()) >= limit {
-				log.Printf("Total time %+v\n", time.Now().Sub(t0))
-				log.Fatalf("Limit of %d results reached, exiting\n", limit)
+			if ew.atLimit() {
+				infof("Total time %+v\n", time.Now().Sub(t0))
+				break
 			}
-		} else if err == io.EOF {
-			log.Printf("Total time %+v\n", time.Now().Sub(t0))
-			break
+		} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// timed out, the rows received so far are kept even for a fresh export
+			o.cacheID = ""
+			if err := ew.finish(); err != nil {
+				return err
+			}
+			bar.Finish()
+			if err := o.commit(); err != nil {
+				return err
+			}
+			infof("timeout of %s exceeded, saved %d partial results to %s", cfg.Timeout, stats.count(), o.outfile)
+			return errTimeout
+		} else if ctx.Err() != nil {
+			// interrupted, rows written in place are kept, a fresh export is discarded
+			o.cacheID = ""
+			if err := ew.finish(); err != nil {
+				return err
+			}
+			bar.Finish()
+			if o.tmp != "" {
+				infof("interrupted after %+v, discarded %d partial results", time.Now().Sub(t0), stats.count())
+			} else {
+				infof("interrupted after %+v, saved %d results", time.Now().Sub(t0), stats.count())
+			}
+			return errInterrupted
 		} else {
-			log.Printf("Load err: %s", err.Error())
-			break
+			errorf("Load err: %s", err.Error())
+			// keep what was reindexed so far, but never cache a partial export
+			o.cacheID = ""
+			if err := ew.finish(); err != nil {
+				errorf("error finishing partial export: %s", err)
+			}
+			return err
 		}
-		t1 = time.Now()
 	}
 	bar.Finish()
-	log.Printf("Done")
+	if err := ew.finish(); err != nil {
+		return err
+	}
+	if err := o.commit(); err != nil {
+		return err
+	}
+	if o.cacheID != "" {
+		if err := storeCache(cfg.CacheDir, o.cacheID, o.outfile); err != nil {
+			return err
+		}
+	}
+	// a completed export starts over on the next run
+	if cp != nil {
+		if err := os.Remove(cfg.Checkpoint); err != nil && !os.IsNotExist(err) {
+			warnf("could not remove checkpoint: %s", err)
+		}
+	}
+	if o.bulk == nil {
+		infof("wrote %d results to %s in %s", stats.count(), o.dest(), time.Since(t0).Round(time.Millisecond))
+	}
+	if bar.Current() > 0 {
+		elapsed := time.Since(t0)
+		debugf("received %d KB of _source, %d bytes per hit, %.0f hits/sec",
+			ew.sourceBytes/1024, ew.sourceBytes/bar.Current(), float64(bar.Current())/elapsed.Seconds())
+	}
+	if ew.limited {
+		// the export is partial, say how much of the search was left behind
+		left := total - bar.Current()
+		if left < 0 {
+			left = 0
+		}
+		infof("wrote %d of %d total matching (%d not exported)", stats.count(), total, left)
+		return errLimitReached
+	}
+	return nil
 }
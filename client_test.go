@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestMaskPassword(t *testing.T) {
+	tests := []struct {
+		password, want string
+	}{
+		{"", " (0)"},
+		{"a", "* (1)"},
+		{"ab", "** (2)"},
+		{"abc", "a*c (3)"},
+		{"hunter2", "h*****2 (7)"},
+		// multi-byte characters are masked and counted as one
+		{"pässwörd", "p******d (8)"},
+	}
+	for _, tt := range tests {
+		if got := maskPassword(tt.password); got != tt.want {
+			t.Errorf("maskPassword(%q) = %q, want %q", tt.password, got, tt.want)
+		}
+	}
+}
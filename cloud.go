@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// cloudURL decodes an Elastic Cloud ID, name:base64(host$es-uuid$kibana-uuid),
+// into the URL of its Elasticsearch endpoint. The host may carry a port,
+// 443 otherwise.
+func cloudURL(cloudID string) (string, error) {
+	i := strings.LastIndex(cloudID, ":")
+	if i < 0 {
+		return "", errors.New("missing the name: prefix, expected name:base64 data as shown in the Elastic Cloud console")
+	}
+	data, err := base64.StdEncoding.DecodeString(cloudID[i+1:])
+	if err != nil {
+		// the console pads the data, but copies may drop the padding
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(cloudID[i+1:], "=")); err != nil {
+			return "", fmt.Errorf("the data after %q is not valid base64: %s", cloudID[:i+1], err)
+		}
+	}
+	parts := strings.Split(string(data), "$")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("decoded data %q does not have the host$es-uuid form", string(data))
+	}
+	host, port := parts[0], "443"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	return fmt.Sprintf("https://%s.%s:%s", parts[1], host, port), nil
+}
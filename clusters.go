@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Cluster definition from the YAML clusters file. Username, password and
+// index fall back to the config and flags when empty.
+type Cluster struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Index    string `yaml:"index"`
+}
+
+// clustersFile is the top-level structure of the YAML clusters file
+type clustersFile struct {
+	Clusters []Cluster `yaml:"clusters"`
+}
+
+// loadClusters reads and validates a clusters file
+func loadClusters(path string) ([]Cluster, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var file clustersFile
+	if err := yaml.NewDecoder(f).Decode(&file); err != nil {
+		return nil, err
+	}
+	if len(file.Clusters) == 0 {
+		return nil, fmt.Errorf("%s lists no clusters", path)
+	}
+	names := make(map[string]bool)
+	for i, c := range file.Clusters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("cluster %d has no name", i+1)
+		} else if names[c.Name] {
+			return nil, fmt.Errorf("cluster name %s is used more than once", c.Name)
+		} else if _, err := url.ParseRequestURI(c.URL); err != nil {
+			return nil, fmt.Errorf("cluster %s has an invalid url: %s", c.Name, c.URL)
+		}
+		names[c.Name] = true
+	}
+	return file.Clusters, nil
+}
+
+// config returns cfg pointed at the cluster
+func (c Cluster) config(cfg Config) Config {
+	cfg.InputURL = c.URL
+	if c.Username != "" {
+		cfg.Username = c.Username
+	}
+	if c.Password != "" {
+		cfg.Password = c.Password
+	}
+	if c.Index != "" {
+		cfg.Index = c.Index
+	}
+	cfg.Cluster = c.Name
+	return cfg
+}
+
+// runClusters runs the search against every cluster, at most workers at a
+// time, and merges the results into cfg.Outfile in the order the clusters are
+// listed. Interrupted and timed out clusters count as failed and are left out.
+// It returns the number of clusters that failed and the failure with the worst
+// exit code after logging a summary.
+func runClusters(ctx context.Context, cfg Config, clusters []Cluster, workers int) (int, error) {
+	parts := make([]string, len(clusters))
+	errs := make([]error, len(clusters))
+	notes := make([]string, len(clusters))
+	if workers > 1 {
+		// concurrent progress bars would draw over each other
+		cfg.NoProgress = true
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c Cluster) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				errs[i] = fmt.Errorf("not run, %w", stopError(ctx))
+				return
+			}
+			part, err := ioutil.TempFile(filepath.Dir(cfg.Outfile), filepath.Base(cfg.Outfile)+"."+c.Name+".*.part")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			part.Close()
+			parts[i] = part.Name()
+			clusterCfg := c.config(cfg)
+			clusterCfg.Outfile = part.Name()
+			// parts stay uncompressed for merging
+			clusterCfg.Gzip = false
+			errs[i] = searchCluster(ctx, clusterCfg)
+			switch errs[i] {
+			case errLimitReached:
+				errs[i], notes[i] = nil, fmt.Sprintf(", limit of %d results reached", cfg.Limit)
+			case errNoResults:
+				errs[i], notes[i] = nil, ", no results"
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	var err error
+	if failed == len(clusters) {
+		// keep an existing outfile rather than replace it with nothing
+		removeParts(parts)
+		errorf("no cluster succeeded, %s was not written", cfg.Outfile)
+	} else if merged, mergeErr := mergeParts(cfg.Outfile, parts, errs, cfg.Format == "csv", cfg.Gzip); mergeErr != nil {
+		errorf("error merging cluster results into %s: %s", cfg.Outfile, mergeErr)
+		err = mergeErr
+	} else {
+		infof("merged %d rows into %s", merged, cfg.Outfile)
+	}
+	infof("cluster summary:")
+	for i, c := range clusters {
+		if errs[i] != nil {
+			infof("  %s: failed: %s", c.Name, errs[i])
+		} else {
+			infof("  %s: ok%s", c.Name, notes[i])
+		}
+	}
+	if err != nil {
+		return len(clusters), err
+	}
+	return failed, worstError(errs)
+}
+
+// searchCluster connects to a single cluster and exports its results
+func searchCluster(ctx context.Context, cfg Config) error {
+	client, err := connect(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	if err := preflight(ctx, client, &cfg); err != nil {
+		return err
+	}
+	return export(ctx, client, cfg)
+}
+
+// mergeParts concatenates the part files of the successful clusters into
+// outfile, keeping a single header line when hasHeader and compressing it
+// when compress, and removes every part file
+func mergeParts(outfile string, parts []string, errs []error, hasHeader, compress bool) (int, error) {
+	defer removeParts(parts)
+	f, err := createOutput(outfile)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var out io.Writer = f
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(f)
+		defer gz.Close()
+		out = gz
+	}
+	w := bufio.NewWriter(out)
+	header := ""
+	rows := 0
+	for i, part := range parts {
+		if part == "" || errs[i] != nil {
+			continue
+		}
+		in, err := os.Open(part)
+		if err != nil {
+			return rows, err
+		}
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		first := hasHeader
+		for scanner.Scan() {
+			line := scanner.Text()
+			// the first line of every part is its header
+			if first {
+				first = false
+				if header == "" {
+					header = line
+					fmt.Fprintln(w, line)
+				}
+				continue
+			} else if hasHeader && line == header {
+				continue
+			}
+			fmt.Fprintln(w, line)
+			rows++
+		}
+		in.Close()
+		if err := scanner.Err(); err != nil {
+			return rows, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return rows, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return rows, err
+		}
+	}
+	return rows, commitOutput(f, outfile)
+}
+
+// removeParts removes the part files of every cluster that got one
+func removeParts(parts []string) {
+	for _, part := range parts {
+		if part != "" {
+			os.Remove(part)
+		}
+	}
+}
+
+// validateClusters connects to every cluster in turn and runs the checks a
+// search starts with, logging ok or the error of each. It returns the number
+// of clusters that failed.
+func validateClusters(ctx context.Context, cfg Config, clusters []Cluster) int {
+	failed := 0
+	infof("cluster check:")
+	for _, c := range clusters {
+		clusterCfg := c.config(cfg)
+		client, err := connect(clusterCfg, clientOptions...)
+		if err == nil {
+			err = preflight(ctx, client, &clusterCfg)
+		}
+		if err != nil {
+			infof("  %s: failed: %s", c.Name, err)
+			failed++
+		} else {
+			infof("  %s: ok", c.Name)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// csvColumns are all the columns a CSV row can have, in their default order
+var csvColumns = append(append(append([]string{"email", "password", "breach_name"}, leakFields...),
+	"raw_index", "matched_context", "cluster", "search_term"), hitMetaColumns...)
+
+// hitMetaColumns are the columns -include-meta adds, taken from the hit
+// instead of its source
+var hitMetaColumns = []string{"_id", "_index", "_score"}
+
+// hitScore formats the relevance score of a hit, empty for sorted searches
+// that don't score
+func hitScore(hit *elastic.SearchHit) string {
+	if hit.Score == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*hit.Score, 'f', -1, 64)
+}
+
+// outputColumn is a column of a -columns layout, written under header
+type outputColumn struct {
+	name   string
+	header string
+}
+
+// parseColumns parses a comma-separated column[:header] list, which selects
+// the CSV columns, their order and their header names
+func parseColumns(spec string) ([]outputColumn, error) {
+	var layout []outputColumn
+	seen := make(map[string]bool)
+	for _, item := range hoardd.SplitList(spec) {
+		name, header := item, item
+		if i := strings.Index(item, ":"); i >= 0 {
+			name, header = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		if !containsString(csvColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, must be one of %s", name, strings.Join(csvColumns, ", "))
+		} else if header == "" || strings.IndexFunc(header, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("invalid header name %q for column %s", header, name)
+		} else if seen[header] {
+			return nil, fmt.Errorf("header name %q is used twice", header)
+		}
+		seen[header] = true
+		layout = append(layout, outputColumn{name: name, header: header})
+	}
+	if len(layout) == 0 {
+		return nil, fmt.Errorf("no columns in %q", spec)
+	}
+	return layout, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configEnv names the config file used when -config is not passed
+const configEnv = "HOARDD_CONFIG"
+
+// defaultConfig returns the settings used when neither a config file nor a
+// flag sets them
+func defaultConfig() Config {
+	return Config{
+		Index:           "leak_*",
+		MaxFieldAction:  "truncate",
+		IPField:         "ip",
+		HashField:       "hash",
+		SaltField:       "salt",
+		NullValues:      "null",
+		PhoneField:      "phone",
+		CacheTTL:        time.Hour,
+		Format:          "csv",
+		Workers:         1,
+		ScrollSize:      10000,
+		ScrollKeepAlive: 5 * time.Minute,
+		DateField:       "breach_date",
+		ExpectCount:     -1,
+	}
+}
+
+// bindFlags registers a flag on fs for every command-line setting of cfg,
+// using the current value of cfg as the flag default
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.InputURL, "url", cfg.InputURL, "URL for ElasticsSearch endpoint")
+	fs.StringVar(&cfg.CloudID, "cloud-id", cfg.CloudID, "Elastic Cloud ID of the deployment, in place of url")
+	fs.StringVar(&cfg.Index, "index", cfg.Index, "Elasticsearch index, or a comma-separated list of indices and patterns, i.e. leak_linkedin")
+	fs.StringVar(&cfg.Username, "username", cfg.Username, "Elasticsearch username")
+	fs.StringVar(&cfg.Password, "password", cfg.Password, "Elasticsearch password, visible in process listings so prefer HOARDD_PASSWORD")
+	fs.StringVar(&cfg.Outfile, "outfile", cfg.Outfile, "Output filename, - for stdout")
+	fs.StringVar(&cfg.EnrichOutfile, "enrich-outfile", cfg.EnrichOutfile, "Write the username, name, phone, ip, hash and salt columns to this CSV file instead, keyed by row number and _id")
+	fs.BoolVar(&cfg.Append, "append", cfg.Append, "Append to the outfile instead of replacing it, with a header only if it is empty")
+	fs.BoolVar(&cfg.Force, "force", cfg.Force, "Overwrite an existing non-empty outfile")
+	fs.StringVar(&cfg.Domain, "domain", cfg.Domain, "domain to search, or a comma-separated list of domains")
+	fs.StringVar(&cfg.Pass, "pass", cfg.Pass, "password to search")
+	fs.StringVar(&cfg.PassFile, "pass-file", cfg.PassFile, "path to a file of password candidates, one per line, searched at once")
+	fs.StringVar(&cfg.Email, "email", cfg.Email, "email to search")
+	fs.StringVar(&cfg.EmailPattern, "email-pattern", cfg.EmailPattern, "Wildcard pattern of the emails to search, i.e. admin*@example.com, or of the local part in domain")
+	fs.StringVar(&cfg.Contains, "contains", cfg.Contains, "Fragment to search anywhere in the emails, i.e. jsmith, requires allow-slow")
+	fs.BoolVar(&cfg.AllowSlow, "allow-slow", cfg.AllowSlow, "Allow searches starting with a wildcard, which scan every indexed email")
+	fs.StringVar(&cfg.IP, "ip", cfg.IP, "IP address or CIDR range to search")
+	fs.StringVar(&cfg.IPField, "ip-field", cfg.IPField, "Elasticsearch field holding IP addresses")
+	fs.StringVar(&cfg.CIDR, "cidr", cfg.CIDR, "Only keep results whose IP field falls within this CIDR range, i.e. 10.0.0.0/8")
+	fs.StringVar(&cfg.User, "user", cfg.User, "username to search")
+	fs.StringVar(&cfg.Hash, "hash", cfg.Hash, "password hash to search")
+	// hash field name
+	fs.StringVar(&cfg.HashField, "hash-field", cfg.HashField, "Elasticsearch field holding password hashes, also written by -format hashcat")
+	fs.StringVar(&cfg.SaltField, "salt-field", cfg.SaltField, "Elasticsearch field holding the salt appended to hashes by -format hashcat, empty for bare hashes")
+	fs.BoolVar(&cfg.HashcatUser, "hashcat-user", cfg.HashcatUser, "Start -format hashcat lines with the email, as email:hash for hashcat --username")
+	fs.StringVar(&cfg.Phone, "phone", cfg.Phone, "phone number to search, in any format such as +1 (555) 123-4567")
+	fs.StringVar(&cfg.PhoneField, "phone-field", cfg.PhoneField, "Elasticsearch field holding phone numbers")
+	fs.StringVar(&cfg.QueryFile, "query-file", cfg.QueryFile, "path to a JSON file with a raw Elasticsearch query to search instead of the search parameters")
+	fs.IntVar(&cfg.Limit, "limit", cfg.Limit, "Maximum number of results to return (default 1,000,000) - set to 0 for no limit")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Enable or disable debug output")
+	fs.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose, "Enable or disable verbose output")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Lowest level of the messages logged: debug, info, warn, or error (default info, debug with verbose or debug)")
+	fs.BoolVar(&cfg.NoProgress, "no-progress", cfg.NoProgress, "Log a progress line every 30 seconds instead of the progress bar, the default when stderr is not a terminal")
+	fs.BoolVar(&cfg.JSONLog, "json-log", cfg.JSONLog, "Write progress as JSON lines to stderr instead of the progress bar")
+	// field length guard
+	fs.IntVar(&cfg.MaxFieldLength, "max-field-length", cfg.MaxFieldLength, "Maximum length in bytes of a single output field - set to 0 for no limit")
+	fs.StringVar(&cfg.MaxFieldAction, "max-field-action", cfg.MaxFieldAction, "Action for fields exceeding max-field-length: truncate or skip")
+	fs.StringVar(&cfg.EncodeFields, "encode-fields", cfg.EncodeFields, "Comma-separated fields to encode on output, i.e. password=base64")
+	fs.BoolVar(&cfg.Highlight, "highlight", cfg.Highlight, "Add a matched_context column with the highlighted part of the match")
+	fs.BoolVar(&cfg.NoPasswordOutput, "no-password-output", cfg.NoPasswordOutput, "Omit the password column and never fetch passwords from the cluster")
+	fs.BoolVar(&cfg.MaskPasswords, "mask-passwords", cfg.MaskPasswords, "Mask passwords on output as first and last character plus length, i.e. p****d (6)")
+	fs.BoolVar(&cfg.IncludeRawIndex, "include-raw-index", cfg.IncludeRawIndex, "Add a raw_index column with the unmodified Elasticsearch index name")
+	fs.BoolVar(&cfg.IncludeMeta, "include-meta", cfg.IncludeMeta, "Add _id, _index and _score columns with the source document and relevance of every result")
+	// query tuning
+	fs.StringVar(&cfg.MinShouldMatch, "min-should-match", cfg.MinShouldMatch, "Minimum number (or percentage) of terms that must match in multi-term searches")
+	fs.BoolVar(&cfg.ListFields, "list-fields", cfg.ListFields, "Sample matching documents and list the fields present instead of exporting")
+	fs.StringVar(&cfg.Fields, "fields", cfg.Fields, "Comma-separated source fields to fetch and write, * for full documents (default the written columns)")
+	fs.BoolVar(&cfg.RequirePassword, "require-password", cfg.RequirePassword, "Skip results with an empty password")
+	fs.BoolVar(&cfg.IncludeEmpty, "include-empty", cfg.IncludeEmpty, "Keep results with an empty email, which are skipped by default")
+	fs.StringVar(&cfg.NullValues, "null-values", cfg.NullValues, "Comma-separated values, compared ignoring case, that count as empty when skipping results")
+	fs.BoolVar(&cfg.FirstOnly, "first-only", cfg.FirstOnly, "Write only the first result of every matching email, for quick exposure checks")
+	fs.BoolVar(&cfg.Dedup, "dedup", cfg.Dedup, "Skip duplicate email and password pairs found in several breaches")
+	fs.StringVar(&cfg.DedupField, "dedup-field", cfg.DedupField, "Dedup on this single field instead, i.e. email (implies dedup)")
+	fs.StringVar(&cfg.After, "after", cfg.After, "Only return results dated after this RFC3339 time, i.e. 2020-01-01T00:00:00Z")
+	fs.StringVar(&cfg.Before, "before", cfg.Before, "Only return results dated before this RFC3339 time")
+	fs.StringVar(&cfg.DateField, "date-field", cfg.DateField, "Elasticsearch date field used by after and before")
+	fs.IntVar(&cfg.Split, "split", cfg.Split, "Start a new numbered outfile every N rows, i.e. output_1.csv, output_2.csv - set to 0 to disable")
+	fs.StringVar(&cfg.Checkpoint, "checkpoint", cfg.Checkpoint, "Record progress in this file and continue an interrupted sorted export from it")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of concurrent sliced scrolls for large exports, or of terms searched at once with input-file")
+	fs.IntVar(&cfg.ScrollSize, "scroll-size", cfg.ScrollSize, "Number of results fetched per scroll batch, lower it if batches time out")
+	fs.Float64Var(&cfg.Rate, "rate", cfg.Rate, "Maximum number of batches fetched per second, i.e. 0.5 for one every 2 seconds - set to 0 for no limit")
+	fs.DurationVar(&cfg.ScrollKeepAlive, "scroll-keepalive", cfg.ScrollKeepAlive, "How long the cluster keeps the scroll context between batches")
+	fs.BoolVar(&cfg.Summary, "summary", cfg.Summary, "Print the number of matching results per breach instead of exporting")
+	fs.Float64Var(&cfg.MinScore, "min-score", cfg.MinScore, "Drop results scoring below this relevance threshold - set to 0 to disable")
+	fs.Int64Var(&cfg.ExpectCount, "expect-count", cfg.ExpectCount, "Fail with exit code 7 unless the search matches exactly this many results - set to -1 to disable")
+	fs.Int64Var(&cfg.ExpectTolerance, "expect-tolerance", cfg.ExpectTolerance, "Number of results the count may differ from expect-count by and still pass")
+	fs.BoolVar(&cfg.CountOnly, "count-only", cfg.CountOnly, "Print the number of matching results instead of exporting")
+	fs.IntVar(&cfg.Sample, "sample", cfg.Sample, "Print the first N matching hits as indented JSON instead of exporting")
+	fs.IntVar(&cfg.SamplePerIndex, "sample-per-index", cfg.SamplePerIndex, "Export N random hits from every matching index instead of all results (max 100)")
+	fs.DurationVar(&cfg.MaxQueryTime, "max-query-time", cfg.MaxQueryTime, "Warn and explain when the count or first batch takes longer than this - set to 0 to disable")
+	fs.BoolVar(&cfg.NormalizeEmail, "normalize-email", cfg.NormalizeEmail, "Also match aliases of the email at well-known providers (plus addressing, gmail dots)")
+	// reindexing
+	fs.StringVar(&cfg.ReindexTo, "reindex-to", cfg.ReindexTo, "Bulk index matching documents into this index instead of writing a file")
+	fs.StringVar(&cfg.SQLite, "sqlite", cfg.SQLite, "Insert matching results into the leaks table of this SQLite database instead of writing a file")
+	fs.StringVar(&cfg.ReindexURL, "reindex-url", cfg.ReindexURL, "URL of the cluster receiving reindexed documents (default same cluster)")
+	fs.StringVar(&cfg.ReindexBreachField, "reindex-breach-field", cfg.ReindexBreachField, "Store the original breach name in this field of reindexed documents")
+	// ordering and recovery
+	fs.StringVar(&cfg.SortBy, "sort-by", cfg.SortBy, "Hold all rows in memory and write them ordered by this output column, i.e. email")
+	fs.StringVar(&cfg.Sort, "sort", cfg.Sort, "Sort results by this field so repeated exports produce the same row order")
+	fs.IntVar(&cfg.ResumeFromLine, "resume-from-line", cfg.ResumeFromLine, "Skip the first N rows of a sorted export and append the rest to the existing outfile")
+	// caching
+	fs.StringVar(&cfg.CacheDir, "cache-dir", cfg.CacheDir, "Cache finished exports in this directory and reuse them for identical queries")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", cfg.CacheTTL, "Maximum age of a cached export")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Maximum duration of the run, i.e. 10m, partial results are kept (default no timeout)")
+	// TLS
+	fs.StringVar(&cfg.CACert, "ca-cert", cfg.CACert, "path to a PEM file with CA certificates to trust for the cluster")
+	fs.BoolVar(&cfg.Insecure, "insecure", cfg.Insecure, "Skip TLS certificate verification (unsafe)")
+	fs.BoolVar(&cfg.SkipHealthCheck, "skip-health-check", cfg.SkipHealthCheck, "Skip the cluster health check, for accounts without cluster privileges")
+	// proxy
+	fs.StringVar(&cfg.Proxy, "proxy", cfg.Proxy, "http://, https:// or socks5:// proxy URL for the cluster connection (default from HTTPS_PROXY)")
+	// debugging
+	fs.StringVar(&cfg.DumpRawResponse, "dump-raw-response", cfg.DumpRawResponse, "Write every raw Elasticsearch response to this file (requires debug)")
+	fs.BoolVar(&cfg.EmbedQuery, "embed-query", cfg.EmbedQuery, "Start the outfile with the query, index, time and total, as # comment lines for csv or a meta object for json")
+	fs.StringVar(&cfg.Format, "format", cfg.Format, "Output format: csv, json (a single array), jsonl (one object per line) or hashcat (hash or hash:salt lines)")
+	fs.StringVar(&cfg.Columns, "columns", cfg.Columns, "Comma-separated CSV columns to write in this order, renamed with column:header, i.e. email:user,password:secret,breach_name:source")
+	fs.StringVar(&cfg.Delimiter, "delimiter", cfg.Delimiter, "CSV field separator, a single character or tab (default \",\")")
+	fs.BoolVar(&cfg.Gzip, "gzip", cfg.Gzip, "Compress the outfile with gzip")
+	// encryption at rest
+	fs.BoolVar(&cfg.Encrypt, "encrypt", cfg.Encrypt, "Encrypt the outfile with a passphrase (prompted, or from HOARDD_PASSPHRASE), decrypt with age -d")
+	fs.StringVar(&cfg.EncryptTo, "encrypt-to", cfg.EncryptTo, "Encrypt the outfile to comma-separated age recipients, public keys or files of them, instead of a passphrase")
+}
+
+// mergeConfig rebuilds cfg from the defaults, then the YAML config files in
+// order, then the flags explicitly passed on the already parsed fs, so a flag
+// always wins over a config file whatever its type
+func mergeConfig(fs *flag.FlagSet, cfg *Config, files ...string) error {
+	passed := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		passed[f.Name] = f.Value.String()
+	})
+	*cfg = defaultConfig()
+	for _, path := range files {
+		if err := loadConfig(path, cfg); err != nil {
+			return err
+		}
+	}
+	// flags are expanded by the shell already
+	expandEnv(cfg)
+	for name, value := range passed {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnv expands $VAR and ${VAR} references in the config file settings
+// for connection details, credentials and paths. Unset variables expand to
+// nothing, with a warning.
+func expandEnv(cfg *Config) {
+	fields := []*string{
+		&cfg.InputURL, &cfg.CloudID, &cfg.Index, &cfg.Username, &cfg.Password, &cfg.Proxy, &cfg.CACert,
+		&cfg.Outfile, &cfg.EnrichOutfile, &cfg.SQLite, &cfg.QueryFile, &cfg.PassFile, &cfg.Checkpoint, &cfg.CacheDir, &cfg.DumpRawResponse,
+		&cfg.ReindexTo, &cfg.ReindexURL, &cfg.EncryptTo,
+	}
+	for _, field := range fields {
+		if !strings.Contains(*field, "$") {
+			continue
+		}
+		*field = os.Expand(*field, func(name string) string {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				warnf("config references unset environment variable %s", name)
+			}
+			return value
+		})
+	}
+}
+
+// globalConfigPath returns the location of the global config file,
+// $HOARDD_CONFIG_HOME/config.yaml or ~/.hoardd/config.yaml
+func globalConfigPath() string {
+	dir := os.Getenv("HOARDD_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".hoardd")
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// configPath returns the config file given by -config, or else by
+// HOARDD_CONFIG, and an error if it does not exist
+func configPath(path string) (string, error) {
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	if path == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("config file %s does not exist", path)
+	} else if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// loadConfig decodes a YAML config file over cfg, leaving settings the file
+// does not mention untouched
+func loadConfig(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return yaml.NewDecoder(f).Decode(cfg)
+}
+
+func isFlagPassed(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mergeTest parses args into a fresh flag set bound to a Config and merges
+// it with a config file holding yml
+func mergeTest(t *testing.T, yml string, args ...string) Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("hoardd-client", flag.ContinueOnError)
+	bindFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeConfig(fs, &cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMergeConfigDebug(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		args []string
+		want bool
+	}{
+		{"yaml only", "debug: true\n", nil, true},
+		{"flag only", "index: leak_linkedin\n", []string{"-debug"}, true},
+		{"neither", "index: leak_linkedin\n", nil, false},
+		// an explicit flag wins over the config file in both directions
+		{"flag disables yaml", "debug: true\n", []string{"-debug=false"}, false},
+		{"flag enables yaml", "debug: false\n", []string{"-debug"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg := mergeTest(t, tt.yml, tt.args...); cfg.Debug != tt.want {
+				t.Errorf("Debug = %v, want %v", cfg.Debug, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeConfigKeepsUnpassedYAML(t *testing.T) {
+	cfg := mergeTest(t, "index: leak_linkedin\nlimit: 50\n", "-limit", "10")
+	if cfg.Index != "leak_linkedin" {
+		t.Errorf("Index = %q, want the config file value leak_linkedin", cfg.Index)
+	}
+	if cfg.Limit != 10 {
+		t.Errorf("Limit = %d, want the flag value 10", cfg.Limit)
+	}
+	// settings in neither keep their defaults
+	if cfg.Format != "csv" || cfg.ExpectCount != -1 {
+		t.Errorf("Format, ExpectCount = %q, %d, want the defaults csv, -1", cfg.Format, cfg.ExpectCount)
+	}
+}
+
+func TestMergeConfigMissingFile(t *testing.T) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("hoardd-client", flag.ContinueOnError)
+	bindFlags(fs, &cfg)
+	if err := mergeConfig(fs, &cfg, filepath.Join(t.TempDir(), "missing.yml")); !os.IsNotExist(err) {
+		t.Errorf("mergeConfig of a missing file = %v, want a not exist error", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// passwordEnv holds the Elasticsearch password, read instead of -password so
+// the secret stays out of shell history and process listings
+const passwordEnv = "HOARDD_PASSWORD"
+
+// promptPassword asks for the Elasticsearch password of username on the
+// terminal. ok is false when stdin is not a terminal.
+func promptPassword(username string) (password string, ok bool, err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", false, nil
+	}
+	fmt.Fprintf(os.Stderr, "Elasticsearch password for %s: ", username)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
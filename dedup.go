@@ -0,0 +1,45 @@
+package main
+
+import (
+	"hash/fnv"
+)
+
+// dedupFilter remembers the rows already written. Keys are stored as 64-bit
+// hashes to bound memory on huge exports, at a negligible risk of a false
+// duplicate.
+type dedupFilter struct {
+	field      string
+	seen       map[uint64]struct{}
+	suppressed int
+}
+
+// newDedupFilter dedups on field, or on the email and password pair when
+// field is empty
+func newDedupFilter(field string) *dedupFilter {
+	return &dedupFilter{field: field, seen: make(map[uint64]struct{})}
+}
+
+// duplicate reports whether a row with the same key was seen before,
+// counting it as suppressed if so
+func (d *dedupFilter) duplicate(l *Leak) bool {
+	h := fnv.New64a()
+	switch d.field {
+	case "":
+		h.Write([]byte(l.Email))
+		h.Write([]byte{0})
+		h.Write([]byte(l.Password))
+	case "email":
+		h.Write([]byte(l.Email))
+	case "password":
+		h.Write([]byte(l.Password))
+	default:
+		h.Write([]byte(l.Field(d.field)))
+	}
+	key := h.Sum64()
+	if _, ok := d.seen[key]; ok {
+		d.suppressed++
+		return true
+	}
+	d.seen[key] = struct{}{}
+	return false
+}
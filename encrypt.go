@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/hoardd/hoardd-client/hoardd"
+	"golang.org/x/term"
+)
+
+// passphraseEnv holds the passphrase for encrypted output, prompted for when unset
+const passphraseEnv = "HOARDD_PASSPHRASE"
+
+// readPassphrase returns the output passphrase from the environment, or
+// prompts for it twice on the terminal
+func readPassphrase() (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("no terminal to prompt for a passphrase, set %s instead", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Output passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("passphrase must not be empty")
+	} else if string(passphrase) != string(confirm) {
+		return "", errors.New("passphrases do not match")
+	}
+	return string(passphrase), nil
+}
+
+// parseRecipients parses a comma-separated list of age recipients, each a
+// public key or a file with one key per line, as written by age-keygen -y
+func parseRecipients(list string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, item := range hoardd.SplitList(list) {
+		if strings.HasPrefix(item, "age1") {
+			recipient, err := age.ParseX25519Recipient(item)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+		f, err := os.Open(item)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", item, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	return recipients, nil
+}
+
+// encryptWriter encrypts everything written to it into w as an age file
+// readable by recipients, or protected by passphrase when there are none. It
+// must be closed to write the final chunk.
+func encryptWriter(w io.Writer, passphrase string, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) > 0 {
+		return age.Encrypt(w, recipients...)
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipient)
+}
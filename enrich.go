@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// enrichWriter writes the optional leak fields of every row to a side file,
+// keyed by the row number that starts each row of the outfile and by _id
+type enrichWriter struct {
+	f *os.File
+	w *bufio.Writer
+	// rows is the format of the outfile, numbering its rows
+	rows   *rowFormat
+	fields []string
+}
+
+// createEnrich creates the side file for the given leak fields as a temporary
+// file next to name, which commit renames into place
+func createEnrich(name string, rows *rowFormat, fields []string, force bool) (*enrichWriter, error) {
+	if err := checkOverwrite(name, force); err != nil {
+		return nil, err
+	}
+	f, err := createOutput(name)
+	if err != nil {
+		return nil, err
+	}
+	e := &enrichWriter{f: f, w: bufio.NewWriter(f), rows: rows, fields: fields}
+	header := append([]string{"row", "_id"}, fields...)
+	if _, err := e.w.WriteString(rows.csvLine(header)); err != nil {
+		e.discard()
+		return nil, err
+	}
+	return e, nil
+}
+
+// write adds the fields of the row just written to the outfile
+func (e *enrichWriter) write(l *Leak, hit *elastic.SearchHit) error {
+	values := []string{strconv.Itoa(e.rows.n), hit.Id}
+	for _, field := range e.fields {
+		values = append(values, e.rows.encodings.apply(field, l.Field(field)))
+	}
+	_, err := e.w.WriteString(e.rows.csvLine(values))
+	return err
+}
+
+// commit flushes the side file and renames it into place as name
+func (e *enrichWriter) commit(name string) error {
+	if err := e.w.Flush(); err != nil {
+		e.discard()
+		return err
+	}
+	return commitOutput(e.f, name)
+}
+
+// discard removes the side file of an export that didn't complete
+func (e *enrichWriter) discard() {
+	e.f.Close()
+	os.Remove(e.f.Name())
+}
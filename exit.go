@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// exit codes, so scripts can tell failures apart
+const (
+	exitFailure     = 1   // query and other errors
+	exitUsage       = 2   // invalid flags, config or input files
+	exitConnection  = 3   // the cluster could not be reached
+	exitAuth        = 4   // the credentials were rejected
+	exitNoResults   = 5   // the search matched nothing, single searches only
+	exitTimeout     = 6   // the timeout passed, partial results were saved
+	exitCount       = 7   // the count did not match expect-count
+	exitInterrupted = 130 // Ctrl-C, partial results are kept only when written in place
+)
+
+// usageError is an invalid flag, config or input file
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+// connectError is a failure to reach the cluster
+type connectError struct {
+	err error
+}
+
+func (e *connectError) Error() string {
+	return fmt.Sprintf("error connecting to elasticsearch: %s", e.err)
+}
+
+func (e *connectError) Unwrap() error {
+	return e.err
+}
+
+// countError is a count query total outside the range set by expect-count
+type countError struct {
+	expected, tolerance, total int64
+}
+
+func (e *countError) Error() string {
+	if e.tolerance > 0 {
+		return fmt.Sprintf("expected %d results (+/- %d), the search matched %d", e.expected, e.tolerance, e.total)
+	}
+	return fmt.Sprintf("expected %d results, the search matched %d", e.expected, e.total)
+}
+
+// checkCount returns a countError when cfg expects a count and total falls
+// outside of it
+func checkCount(cfg Config, total int64) error {
+	if cfg.ExpectCount < 0 {
+		return nil
+	}
+	diff := total - cfg.ExpectCount
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > cfg.ExpectTolerance {
+		return &countError{expected: cfg.ExpectCount, tolerance: cfg.ExpectTolerance, total: total}
+	}
+	debugf("count of %d results matches expect-count %d", total, cfg.ExpectCount)
+	return nil
+}
+
+// isAuthError reports whether err is an authentication or authorization
+// failure returned by the cluster
+func isAuthError(err error) bool {
+	var e *elastic.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden || isSecurityException(e)
+}
+
+// exitSeverity orders the exit codes from worst to least bad: a stopped run
+// first, then failures that affect a whole cluster, then those of one search
+var exitSeverity = []int{exitInterrupted, exitTimeout, exitAuth, exitConnection, exitUsage, exitCount, exitFailure, exitNoResults}
+
+// worstError returns the error of errs whose exit code ranks worst in
+// exitSeverity, or nil when every error is nil
+func worstError(errs []error) error {
+	var worst error
+	rank := len(exitSeverity)
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		for i, code := range exitSeverity {
+			if code == exitCode(err) && i < rank {
+				worst, rank = err, i
+			}
+		}
+	}
+	return worst
+}
+
+// exitCode maps the error returned by run to the exit code of the process
+func exitCode(err error) int {
+	var usage usageError
+	var conn *connectError
+	var count *countError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errNoResults):
+		return exitNoResults
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	case errors.Is(err, errTimeout), errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.As(err, &count):
+		return exitCount
+	case errors.As(err, &usage):
+		return exitUsage
+	case isAuthError(err):
+		return exitAuth
+	case errors.As(err, &conn):
+		return exitConnection
+	}
+	return exitFailure
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWorstError(t *testing.T) {
+	failure := errors.New("query failed")
+	conn := &connectError{err: errors.New("connection refused")}
+	tests := []struct {
+		errs []error
+		want int
+	}{
+		{[]error{nil, nil}, 0},
+		{[]error{nil, failure}, exitFailure},
+		{[]error{failure, conn, nil}, exitConnection},
+		{[]error{conn, fmt.Errorf("not run, %w", errTimeout), failure}, exitTimeout},
+		{[]error{errTimeout, errInterrupted}, exitInterrupted},
+	}
+	for _, tt := range tests {
+		if got := exitCode(worstError(tt.errs)); got != tt.want {
+			t.Errorf("exitCode(worstError(%v)) = %d, want %d", tt.errs, got, tt.want)
+		}
+	}
+}
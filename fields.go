@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// number of documents sampled by -list-fields
+const listFieldsSample = 300
+
+// maximum length of the example value printed by -list-fields
+const listFieldsExampleLength = 40
+
+// fieldInfo tracks how often a field is populated across sampled documents
+type fieldInfo struct {
+	Count   int
+	Example string
+}
+
+// collectFields records an example value for every populated field of a
+// decoded document, using dotted names for nested objects
+func collectFields(examples map[string]string, prefix string, v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			collectFields(examples, name, child)
+		}
+	case []interface{}:
+		for _, child := range value {
+			collectFields(examples, prefix, child)
+		}
+	case nil:
+		// empty fields are exactly what this mode is meant to weed out
+	default:
+		example := fmt.Sprintf("%v", value)
+		if example == "" || prefix == "" {
+			return
+		}
+		if _, ok := examples[prefix]; !ok {
+			examples[prefix] = example
+		}
+	}
+}
+
+// sampleFields decodes the sources of sampled hits into a field union
+func sampleFields(sources []json.RawMessage) (map[string]*fieldInfo, error) {
+	fields := make(map[string]*fieldInfo)
+	for _, source := range sources {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(source, &doc); err != nil {
+			return nil, err
+		}
+		examples := make(map[string]string)
+		collectFields(examples, "", doc)
+		for name, example := range examples {
+			info, ok := fields[name]
+			if !ok {
+				if len(example) > listFieldsExampleLength {
+					example = example[:listFieldsExampleLength] + "..."
+				}
+				info = &fieldInfo{Example: example}
+				fields[name] = info
+			}
+			info.Count++
+		}
+	}
+	return fields, nil
+}
+
+// printFields writes the sampled field union as a table sorted by field name
+func printFields(w io.Writer, fields map[string]*fieldInfo, sampled int) {
+	names := make([]string, 0, len(fields))
+	width := len("field")
+	for name := range fields {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%-*s %8s  %s\n", width, "field", "present", "example")
+	fmt.Fprintf(w, "%s\n", strings.Repeat("-", width+30))
+	for _, name := range names {
+		info := fields[name]
+		fmt.Fprintf(w, "%-*s %3d/%-4d  %s\n", width, name, info.Count, sampled, info.Example)
+	}
+}
+
+// mappedFields returns the fields, in the given order, that are mapped in at
+// least one index matching index
+func mappedFields(ctx context.Context, client *elastic.Client, index string, fields []string) ([]string, error) {
+	mappings, err := client.GetFieldMapping().Index(index).Field(fields...).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make(map[string]bool)
+	for _, m := range mappings {
+		// {"mappings": {field: {"full_name": field, "mapping": {...}}}}
+		if m, ok := m.(map[string]interface{}); ok {
+			if fieldMappings, ok := m["mappings"].(map[string]interface{}); ok {
+				for field := range fieldMappings {
+					mapped[field] = true
+				}
+			}
+		}
+	}
+	var present []string
+	for _, field := range fields {
+		if mapped[field] {
+			present = append(present, field)
+		}
+	}
+	return present, nil
+}
+
+// mappedField is a field of the index mappings, with the types it is mapped
+// as and the number of indices mapping it
+type mappedField struct {
+	Types   []string
+	Indices int
+}
+
+// describeIndex prints the fields mapped in the indices matching index, a
+// union across all of them for patterns
+func describeIndex(ctx context.Context, client *elastic.Client, index string, w io.Writer) error {
+	mappings, err := client.GetMapping().Index(hoardd.SplitList(index)...).Do(ctx)
+	if err != nil {
+		return err
+	} else if len(mappings) == 0 {
+		return fmt.Errorf("no indices match %s", index)
+	}
+	printMapping(w, indexFields(mappings), len(mappings))
+	return nil
+}
+
+// indexFields unions the fields of the mappings returned by the get mapping
+// API, by dotted name including multi-fields
+func indexFields(mappings map[string]interface{}) map[string]*mappedField {
+	fields := make(map[string]*mappedField)
+	for _, m := range mappings {
+		// {"mappings": {"properties": {field: {"type": ...}}}}
+		index, _ := m.(map[string]interface{})
+		mapping, _ := index["mappings"].(map[string]interface{})
+		properties, _ := mapping["properties"].(map[string]interface{})
+		types := make(map[string]string)
+		collectMapping(types, "", properties)
+		for name, typ := range types {
+			field, ok := fields[name]
+			if !ok {
+				field = &mappedField{}
+				fields[name] = field
+			}
+			field.Indices++
+			if !containsString(field.Types, typ) {
+				field.Types = append(field.Types, typ)
+			}
+		}
+	}
+	return fields
+}
+
+// collectMapping records the type of every field in properties, using dotted
+// names for object fields and multi-fields
+func collectMapping(types map[string]string, prefix string, properties map[string]interface{}) {
+	for name, p := range properties {
+		field, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		typ, _ := field["type"].(string)
+		if children, ok := field["properties"].(map[string]interface{}); ok {
+			if typ == "" {
+				typ = "object"
+			}
+			collectMapping(types, name, children)
+		}
+		types[name] = typ
+		// i.e. email.keyword next to a text email field
+		if multi, ok := field["fields"].(map[string]interface{}); ok {
+			collectMapping(types, name, multi)
+		}
+	}
+}
+
+// printMapping writes the mapped fields as a table sorted by field name,
+// with the indices mapping each field out of all matched indices
+func printMapping(w io.Writer, fields map[string]*mappedField, indices int) {
+	names := make([]string, 0, len(fields))
+	width := len("field")
+	for name := range fields {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%-*s %-20s %s\n", width, "field", "type", "indices")
+	fmt.Fprintf(w, "%s\n", strings.Repeat("-", width+30))
+	for _, name := range names {
+		field := fields[name]
+		sort.Strings(field.Types)
+		// fields mapped as different types in different indices list them all
+		fmt.Fprintf(w, "%-*s %-20s %d/%d\n", width, name, strings.Join(field.Types, ", "), field.Indices, indices)
+	}
+}
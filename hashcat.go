@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// hashcatLine renders a leak as a hashcat input line, hash or hash:salt when
+// the leak has a salt, prefixed with email: for hashcat-user. Leaks without a
+// hash render as nothing.
+func (r *rowFormat) hashcatLine(l *Leak, hit *elastic.SearchHit) string {
+	hash := r.hash(l, hit)
+	if hoardd.IsNullValue(hash, r.nullValues) {
+		return ""
+	}
+	line := hash
+	if salt := leakValue(r.saltField, l, hit); !hoardd.IsNullValue(salt, r.nullValues) {
+		line += ":" + salt
+	}
+	if r.hashcatUser {
+		// hashcat --username would read the hash as the user
+		if hoardd.IsNullValue(l.Email, r.nullValues) {
+			return ""
+		}
+		line = l.Email + ":" + line
+	}
+	// a line break in a value would split one hash across lines
+	if strings.ContainsAny(line, "\r\n") {
+		return ""
+	}
+	r.n++
+	return line + "\n"
+}
+
+// hash returns the hash of a leak as read from the hash field
+func (r *rowFormat) hash(l *Leak, hit *elastic.SearchHit) string {
+	return leakValue(r.hashField, l, hit)
+}
+
+// leakValue returns a field of a leak, reading fields outside the leak fields
+// from the source of the hit by dotted name
+func leakValue(field string, l *Leak, hit *elastic.SearchHit) string {
+	if field == "" {
+		return ""
+	} else if containsString(leakFields, field) {
+		return l.Field(field)
+	}
+	return sourceValue(hit.Source, field)
+}
+
+// sourceValue returns the text of a field of a document source by dotted
+// name, empty for missing fields and for objects and arrays
+func sourceValue(source json.RawMessage, field string) string {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return ""
+	}
+	// a flat field named with dots wins over a nested object
+	if raw, ok := doc[field]; ok {
+		var value hoardd.Text
+		if len(raw) > 0 && raw[0] != '{' && raw[0] != '[' && json.Unmarshal(raw, &value) == nil {
+			return string(value)
+		}
+		return ""
+	}
+	if i := strings.Index(field, "."); i > 0 {
+		if raw, ok := doc[field[:i]]; ok {
+			return sourceValue(raw, field[i+1:])
+		}
+	}
+	return ""
+}
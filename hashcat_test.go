@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+func TestHashcatLine(t *testing.T) {
+	tests := []struct {
+		source      string
+		hashcatUser bool
+		want        string
+	}{
+		{`{"email":"a@example.com","hash":"5f4d","salt":"x1"}`, false, "5f4d:x1\n"},
+		{`{"email":"a@example.com","hash":"5f4d"}`, false, "5f4d\n"},
+		// bare lines don't need the email
+		{`{"hash":"5f4d"}`, false, "5f4d\n"},
+		{`{"email":"a@example.com","hash":"5f4d","salt":"x1"}`, true, "a@example.com:5f4d:x1\n"},
+		{`{"email":"a@example.com","hash":"5f4d"}`, true, "a@example.com:5f4d\n"},
+		{`{"email":"null","hash":"5f4d"}`, true, ""},
+		{`{"email":"a@example.com","hash":"NULL"}`, true, ""},
+		{`{"email":"a@example.com","hash":"5f\n4d"}`, false, ""},
+	}
+	for _, tt := range tests {
+		hit := &elastic.SearchHit{Source: json.RawMessage(tt.source)}
+		l, err := hoardd.DecodeLeak(hit.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &rowFormat{format: "hashcat", hashField: "hash", saltField: "salt", hashcatUser: tt.hashcatUser,
+			nullValues: []string{"null"}}
+		if got := r.hashcatLine(l, hit); got != tt.want {
+			t.Errorf("hashcatLine(%s, hashcatUser %v) = %q, want %q", tt.source, tt.hashcatUser, got, tt.want)
+		}
+	}
+}
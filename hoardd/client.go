@@ -0,0 +1,164 @@
+// Package hoardd searches the breach indices of the Hoardd OSINT platform for
+// leaked credentials. The hoardd-client command is built on it.
+//
+//	client, err := hoardd.Connect("https://hoardd.example.com:9200", user, password, "leak_*")
+//	if err != nil {
+//		return err
+//	}
+//	leaks, err := client.Search(ctx, hoardd.Query{Domain: "example.com"})
+//	if err != nil {
+//		return err
+//	}
+//	for leak := range leaks {
+//		fmt.Println(leak.Email, leak.Password, leak.Breach())
+//	}
+package hoardd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Client searches the leak indices of a cluster
+type Client struct {
+	es      *elastic.Client
+	indices []string
+	// ScrollSize is the number of hits fetched per scroll request
+	ScrollSize int
+	// KeepAlive is how long the cluster keeps the scroll context between requests
+	KeepAlive time.Duration
+	// NullValues are the emails, besides an empty one, that count as no email
+	NullValues []string
+	// MinScore drops hits scoring below it from counts and scans, 0 keeps all
+	MinScore float64
+	// Malformed is called with every hit whose source cannot be decoded, which
+	// is skipped rather than ending the search. Nil skips them silently.
+	Malformed func(hit *elastic.SearchHit, err error)
+}
+
+// NewClient searches index, a comma-separated list of indices and patterns,
+// through an existing Elasticsearch client. An empty index searches leak_*.
+func NewClient(es *elastic.Client, index string) *Client {
+	indices := SplitList(index)
+	if len(indices) == 0 {
+		indices = []string{"leak_*"}
+	}
+	return &Client{es: es, indices: indices, ScrollSize: 10000, KeepAlive: 5 * time.Minute, NullValues: DefaultNullValues}
+}
+
+// Connect creates a client for the cluster at url with basic authentication
+func Connect(url, username, password, index string, options ...elastic.ClientOptionFunc) (*Client, error) {
+	options = append([]elastic.ClientOptionFunc{
+		elastic.SetURL(url),
+		elastic.SetSniff(false),
+		elastic.SetBasicAuth(username, password),
+	}, options...)
+	es, err := elastic.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(es, index), nil
+}
+
+// Indices returns the indices, aliases and patterns searched by the client
+func (c *Client) Indices() []string {
+	return c.indices
+}
+
+// Compile builds the Elasticsearch query for q against the indices of the
+// client, for callers sending their own requests with the compiled query
+func (c *Client) Compile(ctx context.Context, q Query) (*Compiled, error) {
+	return q.Compile(ctx, c.es, c.indices...)
+}
+
+// Count returns the number of results matching q
+func (c *Client) Count(ctx context.Context, q Query) (int64, error) {
+	compiled, err := c.Compile(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	return c.CountCompiled(ctx, compiled)
+}
+
+// CountCompiled returns the number of results matching a compiled query
+func (c *Client) CountCompiled(ctx context.Context, compiled *Compiled) (int64, error) {
+	count := c.es.Count(c.indices...).Query(compiled.Query)
+	if c.MinScore > 0 {
+		count = count.MinScore(c.MinScore)
+	}
+	return count.Do(ctx)
+}
+
+// Scan calls fn for every leak matching q until all results were seen, fn
+// returns an error or ctx is cancelled. Leaks without an email and malformed
+// hits are skipped, the latter reported to Malformed.
+func (c *Client) Scan(ctx context.Context, q Query, fn func(Leak) error) error {
+	compiled, err := c.Compile(ctx, q)
+	if err != nil {
+		return err
+	}
+	return c.scan(ctx, compiled, fn)
+}
+
+// Search streams the leaks matching q. The query is compiled before Search
+// returns, so invalid queries fail immediately. The channel is closed after
+// the last leak, or early when ctx is cancelled or a scroll request fails;
+// use Scan to handle those errors.
+func (c *Client) Search(ctx context.Context, q Query) (<-chan Leak, error) {
+	compiled, err := c.Compile(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	leaks := make(chan Leak)
+	go func() {
+		defer close(leaks)
+		c.scan(ctx, compiled, func(l Leak) error {
+			select {
+			case leaks <- l:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return leaks, nil
+}
+
+func (c *Client) scan(ctx context.Context, compiled *Compiled, fn func(Leak) error) error {
+	keepAlive := fmt.Sprintf("%ds", int64(c.KeepAlive/time.Second))
+	ss := elastic.NewSearchSource().Query(compiled.Query)
+	if c.MinScore > 0 {
+		ss = ss.MinScore(c.MinScore)
+	}
+	scroll := c.es.Scroll(c.indices...).KeepAlive(keepAlive).Size(c.ScrollSize).SearchSource(ss)
+	// free the scroll context on the cluster, even when cancelled
+	defer scroll.Clear(context.Background())
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, hit := range res.Hits.Hits {
+			l, err := DecodeLeak(hit.Source)
+			if err != nil {
+				if c.Malformed != nil {
+					c.Malformed(hit, err)
+				}
+				continue
+			}
+			if IsNullValue(l.Email, c.NullValues) {
+				continue
+			}
+			l.Index = hit.Index
+			if err := fn(*l); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package hoardd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// countRequest is what the fake cluster received on its count API
+type countRequest struct {
+	minScore string
+	body     map[string]interface{}
+}
+
+// fakeCluster serves the count API with a fixed count, recording every request
+// so tests can check what the client asked for rather than what it got back
+func fakeCluster(t *testing.T, count int) (*elastic.Client, *[]countRequest) {
+	t.Helper()
+	var requests []countRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_count") {
+			http.NotFound(w, r)
+			return
+		}
+		req := countRequest{minScore: r.URL.Query().Get("min_score")}
+		data, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(data, &req.body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"count": count})
+	}))
+	t.Cleanup(srv.Close)
+	es, err := elastic.NewClient(elastic.SetURL(srv.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es, &requests
+}
+
+// queryStrings returns the query_string queries anywhere in a query body
+func queryStrings(v interface{}) []string {
+	var found []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if qs, ok := value.(map[string]interface{}); ok && key == "query_string" {
+				if s, ok := qs["query"].(string); ok {
+					found = append(found, s)
+				}
+				continue
+			}
+			found = append(found, queryStrings(value)...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = append(found, queryStrings(value)...)
+		}
+	}
+	return found
+}
+
+func TestCountMinScore(t *testing.T) {
+	tests := []struct {
+		minScore float64
+		want     string
+	}{
+		// no min_score is sent when every hit is kept
+		{0, ""},
+		{0.5, "0.5"},
+		{2.5, "2.5"},
+	}
+	for _, tt := range tests {
+		es, requests := fakeCluster(t, 7)
+		client := NewClient(es, "leak_*")
+		client.MinScore = tt.minScore
+		total, err := client.Count(context.Background(), Query{Email: "foo@bar.com"})
+		if err != nil {
+			t.Fatalf("Count at min score %g failed: %s", tt.minScore, err)
+		}
+		if len(*requests) != 1 {
+			t.Fatalf("Count at min score %g sent %d requests, want 1", tt.minScore, len(*requests))
+		}
+		req := (*requests)[0]
+		if req.minScore != tt.want {
+			t.Errorf("Count at min score %g sent min_score %q, want %q", tt.minScore, req.minScore, tt.want)
+		}
+		if got := queryStrings(req.body["query"]); len(got) != 1 || got[0] != `email:"foo@bar.com"` {
+			t.Errorf("Count at min score %g searched %q, want the exact email term", tt.minScore, got)
+		}
+		// the count is the cluster's, the client filters nothing itself
+		if total != 7 {
+			t.Errorf("Count at min score %g = %d, want 7", tt.minScore, total)
+		}
+	}
+}
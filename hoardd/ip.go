@@ -0,0 +1,133 @@
+package hoardd
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// ParseIPSearch parses a single address or a CIDR range into a network
+func ParseIPSearch(value string) (*net.IPNet, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		} else {
+			ip = ip.To4()
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither an IP address nor a CIDR range", value)
+	}
+	return network, nil
+}
+
+// ipFieldTypes returns the indices matching index grouped by whether field is
+// mapped with the native ip type
+func ipFieldTypes(ctx context.Context, client *elastic.Client, index, field string) (ipIndices, otherIndices []string, err error) {
+	mappings, err := client.GetFieldMapping().Index(index).Field(field).Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, m := range mappings {
+		fieldType := ""
+		// {"mappings": {field: {"mapping": {leaf: {"type": "ip"}}}}}
+		if m, ok := m.(map[string]interface{}); ok {
+			if fields, ok := m["mappings"].(map[string]interface{}); ok {
+				if f, ok := fields[field].(map[string]interface{}); ok {
+					if leaves, ok := f["mapping"].(map[string]interface{}); ok {
+						for _, leaf := range leaves {
+							if leaf, ok := leaf.(map[string]interface{}); ok {
+								fieldType, _ = leaf["type"].(string)
+							}
+						}
+					}
+				}
+			}
+		}
+		if fieldType == "ip" {
+			ipIndices = append(ipIndices, name)
+		} else if fieldType != "" {
+			otherIndices = append(otherIndices, name)
+		}
+	}
+	return ipIndices, otherIndices, nil
+}
+
+// ipPrefixQuery matches an IPv4 network on a keyword field by expanding it to
+// the octet-aligned string prefixes it covers
+func ipPrefixQuery(field string, network *net.IPNet) (elastic.Query, error) {
+	ip := network.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("IPv6 ranges require the %s field to be mapped as ip type", field)
+	}
+	ones, _ := network.Mask.Size()
+	if ones == 0 {
+		return elastic.NewExistsQuery(field), nil
+	}
+	// extend the prefix to the next octet boundary, at most 2^7 blocks
+	octets := (ones + 7) / 8
+	blocks := 1 << uint(octets*8-ones)
+	queries := make([]elastic.Query, 0, blocks)
+	base := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	for i := 0; i < blocks; i++ {
+		addr := base + uint32(i)<<uint(32-octets*8)
+		parts := []uint32{addr >> 24, addr >> 16 & 0xff, addr >> 8 & 0xff, addr & 0xff}
+		if octets == 4 {
+			queries = append(queries, elastic.NewTermQuery(field,
+				fmt.Sprintf("%d.%d.%d.%d", parts[0], parts[1], parts[2], parts[3])))
+			continue
+		}
+		prefix := ""
+		for _, part := range parts[:octets] {
+			prefix += fmt.Sprintf("%d.", part)
+		}
+		queries = append(queries, elastic.NewPrefixQuery(field, prefix))
+	}
+	return elastic.NewBoolQuery().Should(queries...), nil
+}
+
+// ipQuery builds the query for an IP or CIDR search on field, picking the
+// native CIDR term query for indices mapping the field as ip type and prefix
+// expansion for the rest
+func ipQuery(ctx context.Context, client *elastic.Client, index, field, value string) (elastic.Query, error) {
+	network, err := ParseIPSearch(value)
+	if err != nil {
+		return nil, err
+	}
+	ipIndices, otherIndices, err := ipFieldTypes(ctx, client, index, field)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipIndices) == 0 && len(otherIndices) == 0 {
+		return nil, fmt.Errorf("no index matching %s has a %s field", index, field)
+	}
+	cidrQuery := elastic.NewTermQuery(field, network.String())
+	if len(otherIndices) == 0 {
+		return cidrQuery, nil
+	}
+	prefixQuery, err := ipPrefixQuery(field, network)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipIndices) == 0 {
+		return prefixQuery, nil
+	}
+	// mixed mappings, scope each approach to the indices it works on
+	return elastic.NewBoolQuery().Should(
+		elastic.NewBoolQuery().Filter(elastic.NewTermsQuery("_index", stringsToInterfaces(ipIndices)...)).Must(cidrQuery),
+		elastic.NewBoolQuery().Filter(elastic.NewTermsQuery("_index", stringsToInterfaces(otherIndices)...)).Must(prefixQuery),
+	), nil
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package hoardd
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Leak definition from ElasticSearch JSON structure
+type Leak struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Username Text   `json:"username"`
+	Name     Text   `json:"name"`
+	Phone    Text   `json:"phone"`
+	IP       Text   `json:"ip"`
+	Hash     Text   `json:"hash"`
+	Salt     Text   `json:"salt"`
+	// Index is the Elasticsearch index the leak was found in
+	Index string `json:"-"`
+}
+
+// DecodeLeak parses the _source of a hit, which may be missing or malformed
+func DecodeLeak(source json.RawMessage) (*Leak, error) {
+	if len(source) == 0 {
+		return nil, errors.New("no _source")
+	}
+	var l *Leak
+	if err := json.Unmarshal(source, &l); err != nil {
+		return nil, err
+	} else if l == nil {
+		return nil, errors.New("null _source")
+	}
+	return l, nil
+}
+
+// LeakFields are the optional fields of a leak, beyond email and password
+var LeakFields = []string{"username", "name", "phone", "ip", "hash", "salt"}
+
+// Field returns the value of an optional leak field by name
+func (l *Leak) Field(name string) string {
+	switch name {
+	case "username":
+		return string(l.Username)
+	case "name":
+		return string(l.Name)
+	case "phone":
+		return string(l.Phone)
+	case "ip":
+		return string(l.IP)
+	case "hash":
+		return string(l.Hash)
+	case "salt":
+		return string(l.Salt)
+	}
+	return ""
+}
+
+// DefaultNullValues are the junk values a field is treated as empty for when
+// nothing else is configured
+var DefaultNullValues = []string{"null"}
+
+// IsNullValue reports whether value is empty or, ignoring case and surrounding
+// spaces, one of nullValues such as null or n/a
+func IsNullValue(value string, nullValues []string) bool {
+	if value == "" {
+		return true
+	}
+	value = strings.TrimSpace(value)
+	for _, null := range nullValues {
+		if strings.EqualFold(value, null) {
+			return true
+		}
+	}
+	return false
+}
+
+// Breach returns the name of the breach the leak was found in
+func (l *Leak) Breach() string {
+	return BreachName(l.Index)
+}
+
+// BreachName returns the breach name of an index, i.e. linkedin for leak_linkedin
+func BreachName(index string) string {
+	return strings.TrimPrefix(index, "leak_")
+}
+
+// Text is a leak field that may be indexed as a string, number or bool,
+// and is always output as text
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = Text(s)
+	} else if string(data) == "null" {
+		*t = ""
+	} else {
+		*t = Text(data)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package hoardd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeLeak(t *testing.T) {
+	tests := []struct {
+		name   string
+		source json.RawMessage
+		want   *Leak
+	}{
+		{"nil", nil, nil},
+		{"empty", json.RawMessage(""), nil},
+		{"null", json.RawMessage("null"), nil},
+		{"broken", json.RawMessage(`{"email":"user@example.com",`), nil},
+		{"not an object", json.RawMessage(`["user@example.com"]`), nil},
+		{"email type", json.RawMessage(`{"email":42}`), nil},
+		{"leak", json.RawMessage(`{"email":"user@example.com","password":"hunter2"}`),
+			&Leak{Email: "user@example.com", Password: "hunter2"}},
+		// optional fields indexed as numbers are read as text
+		{"numeric phone", json.RawMessage(`{"email":"user@example.com","phone":15551234567,"ip":null}`),
+			&Leak{Email: "user@example.com", Phone: "15551234567"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := DecodeLeak(tt.source)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("DecodeLeak(%s) = %+v, want an error", tt.source, l)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeLeak(%s) failed: %s", tt.source, err)
+			}
+			if *l != *tt.want {
+				t.Errorf("DecodeLeak(%s) = %+v, want %+v", tt.source, *l, *tt.want)
+			}
+		})
+	}
+}
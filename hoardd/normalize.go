@@ -0,0 +1,83 @@
+package hoardd
+
+import (
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// email normalization rules
+//
+// only well-known providers are normalized, everything else is searched as-is:
+//   - gmail.com / googlemail.com: the +tag suffix is stripped, dots in the local
+//     part are ignored and both domains deliver to the same mailbox
+//   - the providers in plusAddressingProviders: the +tag suffix is stripped
+var plusAddressingProviders = map[string]bool{
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"icloud.com":     true,
+	"protonmail.com": true,
+	"proton.me":      true,
+	"fastmail.com":   true,
+}
+
+func isGmail(domain string) bool {
+	return domain == "gmail.com" || domain == "googlemail.com"
+}
+
+// regexpEscape escapes every non-alphanumeric rune for a Lucene regexp
+func regexpEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quoteTerm quotes a user-supplied term as a query string phrase, escaping the
+// quotes and backslashes that would otherwise end it or inject query syntax
+func quoteTerm(term string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(term) + `"`
+}
+
+// wildcardEscape escapes the wildcard characters of a literal wildcard query part
+func wildcardEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
+
+// emailVariants returns queries matching every alias of the mailbox behind
+// email, or nil if the provider has no known normalization rules
+func emailVariants(email string) []elastic.Query {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return nil
+	}
+	local, domain := strings.ToLower(email[:at]), strings.ToLower(email[at+1:])
+	if !isGmail(domain) && !plusAddressingProviders[domain] {
+		return nil
+	}
+	if i := strings.Index(local, "+"); i >= 0 {
+		local = local[:i]
+	}
+	queries := []elastic.Query{elastic.NewQueryStringQuery("email:" + quoteTerm(email))}
+	if !isGmail(domain) {
+		return append(queries,
+			elastic.NewQueryStringQuery("email:"+quoteTerm(local+"@"+domain)),
+			elastic.NewWildcardQuery("email", wildcardEscape(local)+"+*@"+wildcardEscape(domain)))
+	}
+	// gmail ignores dots, so allow an optional dot between every character
+	local = strings.Replace(local, ".", "", -1)
+	var pattern strings.Builder
+	for i, r := range local {
+		if i > 0 {
+			pattern.WriteString(`\.?`)
+		}
+		pattern.WriteString(regexpEscape(string(r)))
+	}
+	pattern.WriteString(`(\+.*)?\@(gmail|googlemail)\.com`)
+	return append(queries, elastic.NewRegexpQuery("email", pattern.String()))
+}
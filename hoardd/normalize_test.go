@@ -0,0 +1,43 @@
+package hoardd
+
+import "testing"
+
+func TestQuoteTerm(t *testing.T) {
+	tests := []struct {
+		term, want string
+	}{
+		{"user@example.com", `"user@example.com"`},
+		{`pa"ss`, `"pa\"ss"`},
+		{`back\slash`, `"back\\slash"`},
+		// a trailing backslash must not escape the closing quote
+		{`end\`, `"end\\"`},
+		{`\"`, `"\\\""`},
+		// query syntax is inert inside the phrase
+		{"email:admin OR *", `"email:admin OR *"`},
+		{"a:b*c", `"a:b*c"`},
+	}
+	for _, tt := range tests {
+		if got := quoteTerm(tt.term); got != tt.want {
+			t.Errorf("quoteTerm(%q) = %s, want %s", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestWildcardEscape(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"jsmith", "jsmith"},
+		{"j*smith", `j\*smith`},
+		{"j?smith", `j\?smith`},
+		{`j\smith`, `j\\smith`},
+		{`\*`, `\\\*`},
+		// colons and quotes have no meaning in a wildcard query
+		{`a:b"c`, `a:b"c`},
+	}
+	for _, tt := range tests {
+		if got := wildcardEscape(tt.s); got != tt.want {
+			t.Errorf("wildcardEscape(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package hoardd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// minPhoneDigits is the shortest phone number searched, shorter ones would
+// match far too much
+const minPhoneDigits = 5
+
+// NormalizePhone strips the spaces, dashes, dots, parentheses and leading +
+// of a phone number, returning its digits
+func NormalizePhone(phone string) (string, error) {
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '.', r == '(', r == ')':
+		default:
+			return "", fmt.Errorf("invalid character %q in phone number %q", r, phone)
+		}
+	}
+	if digits.Len() < minPhoneDigits {
+		return "", errors.New("a phone number needs at least 5 digits")
+	}
+	return digits.String(), nil
+}
+
+// phoneVariants returns the spellings of a normalized phone number searched,
+// since breaches store them with and without the + prefix, and North American
+// numbers also with and without the country code and in dashed groups
+func phoneVariants(digits string) []string {
+	variants := []string{digits, "+" + digits}
+	national := digits
+	if len(digits) == 11 && digits[0] == '1' {
+		national = digits[1:]
+		variants = append(variants, national)
+	}
+	if len(national) == 10 {
+		variants = append(variants, national[:3]+"-"+national[3:6]+"-"+national[6:])
+	}
+	return variants
+}
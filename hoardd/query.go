@@ -0,0 +1,253 @@
+package hoardd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Query describes a search. Exactly one of Email, EmailPattern, Contains, Domain, Pass,
+// Passes, IP, User, Hash, Phone and Raw must be set, except that EmailPattern can be combined with
+// Domain.
+type Query struct {
+	Email string
+	// EmailPattern is a wildcard pattern of the whole email, or of the local
+	// part in Domain, or any domain, when it has no @
+	EmailPattern string
+	// Contains is a fragment matched anywhere in the email, with a leading
+	// wildcard that scans every indexed email
+	Contains string
+	// Domain is a domain, or a comma-separated list of domains
+	Domain string
+	Pass   string
+	// Passes are password candidates, any of which matches exactly
+	Passes []string
+	// IP is an address or a CIDR range searched in IPField, default ip
+	IP      string
+	IPField string
+	User    string
+	// Hash is a password hash searched in HashField, default hash
+	Hash      string
+	HashField string
+	// Phone is a phone number in any format searched in PhoneField, default phone
+	Phone      string
+	PhoneField string
+	// CIDR limits any search to results with an IPField in the range
+	CIDR string
+	// NormalizeEmail also matches aliases of Email at well-known providers
+	NormalizeEmail bool
+	// MinShouldMatch is the number (or percentage) of terms that must match
+	// in multi-term searches, default 1
+	MinShouldMatch string
+	// After and Before limit results to an RFC3339 date range on DateField
+	After     string
+	Before    string
+	DateField string
+	// Raw is a query in the Elasticsearch query DSL, searched as is
+	Raw string
+}
+
+// Compiled is a query ready to be sent to the cluster
+type Compiled struct {
+	Query elastic.Query
+	// String is the query in query string syntax, for logging
+	String string
+	// Field is the field the search matches on, for highlighting
+	Field string
+	// Domains of a multi-domain search
+	Domains []string
+	// Warnings about settings that were ignored
+	Warnings []string
+}
+
+// Compile builds the Elasticsearch query for q. IP searches and CIDR filters
+// look up the mapping of the IP field in indices to pick a query that works on it.
+func (q Query) Compile(ctx context.Context, client *elastic.Client, indices ...string) (*Compiled, error) {
+	c := &Compiled{Field: "email"}
+	ipField, hashField := q.IPField, q.HashField
+	if ipField == "" {
+		ipField = "ip"
+	}
+	if hashField == "" {
+		hashField = "hash"
+	}
+	phoneField := q.PhoneField
+	if phoneField == "" {
+		phoneField = "phone"
+	}
+	// multi-term searches OR their terms together as should clauses
+	var shouldQueries []elastic.Query
+	// searches that cannot be expressed as a query string set the query directly
+	var termQuery elastic.Query
+	if q.Raw != "" {
+		// the date range still applies, the raw query is wrapped like any other
+		c.String = q.Raw
+		termQuery = elastic.NewRawStringQuery(q.Raw)
+	} else if q.EmailPattern != "" {
+		patterns, err := emailPatterns(q.EmailPattern, SplitList(q.Domain))
+		if err != nil {
+			return nil, err
+		}
+		clauses := make([]string, len(patterns))
+		for i, pattern := range patterns {
+			clauses[i] = "email:" + pattern
+			termQuery = elastic.NewWildcardQuery("email", pattern)
+			if len(patterns) > 1 {
+				shouldQueries = append(shouldQueries, termQuery)
+			}
+		}
+		c.String = strings.Join(clauses, " OR ")
+		if len(patterns) > 1 {
+			c.Domains = SplitList(q.Domain)
+		}
+	} else if q.Contains != "" {
+		pattern := "*" + wildcardEscape(strings.ToLower(strings.TrimSpace(q.Contains))) + "*"
+		c.String = "email:" + pattern
+		termQuery = elastic.NewWildcardQuery("email", pattern)
+		c.Warnings = append(c.Warnings, fmt.Sprintf("searching emails containing %q scans every indexed email, "+
+			"narrow -index to specific breaches to speed it up", q.Contains))
+	} else if q.Email != "" {
+		c.String = "email:" + quoteTerm(q.Email)
+		if q.NormalizeEmail {
+			shouldQueries = emailVariants(q.Email)
+			if shouldQueries == nil {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("no normalization rules for %s, searching the exact address only", q.Email))
+			}
+		}
+	} else if domains := SplitList(q.Domain); len(domains) > 1 {
+		// several domains are ORed together
+		clauses := make([]string, len(domains))
+		for i, domain := range domains {
+			clauses[i] = "email:" + quoteTerm("*@"+domain)
+			shouldQueries = append(shouldQueries, elastic.NewQueryStringQuery(clauses[i]))
+		}
+		c.String = strings.Join(clauses, " OR ")
+		c.Domains = domains
+	} else if q.Domain != "" {
+		c.String = "email:" + quoteTerm("*@"+q.Domain)
+	} else if q.Pass != "" {
+		c.String = "password:" + quoteTerm(q.Pass)
+		c.Field = "password"
+	} else if len(q.Passes) > 0 {
+		// a single terms query instead of a clause per candidate
+		clauses := make([]string, len(q.Passes))
+		candidates := make([]interface{}, len(q.Passes))
+		for i, password := range q.Passes {
+			clauses[i] = quoteTerm(password)
+			candidates[i] = password
+		}
+		c.String = "password:(" + strings.Join(clauses, " OR ") + ")"
+		c.Field = "password"
+		termQuery = elastic.NewTermsQuery("password", candidates...)
+	} else if q.IP != "" {
+		c.Field = ipField
+		c.String = fmt.Sprintf(`%s:%v`, ipField, q.IP)
+		var err error
+		termQuery, err = ipQuery(ctx, client, strings.Join(indices, ","), ipField, q.IP)
+		if err != nil {
+			return nil, err
+		}
+	} else if q.User != "" {
+		c.String = "username:" + quoteTerm(q.User)
+		c.Field = "username"
+	} else if q.Hash != "" {
+		c.String = hashField + ":" + quoteTerm(q.Hash)
+		c.Field = hashField
+	} else if q.Phone != "" {
+		digits, err := NormalizePhone(q.Phone)
+		if err != nil {
+			return nil, err
+		}
+		// any of the spellings matches
+		variants := phoneVariants(digits)
+		clauses := make([]string, len(variants))
+		queries := make([]elastic.Query, len(variants))
+		for i, variant := range variants {
+			clauses[i] = phoneField + ":" + quoteTerm(variant)
+			queries[i] = elastic.NewQueryStringQuery(clauses[i])
+		}
+		c.String = strings.Join(clauses, " OR ")
+		c.Field = phoneField
+		termQuery = elastic.NewBoolQuery().Should(queries...).MinimumNumberShouldMatch(1)
+	} else {
+		return nil, errors.New("email, email-pattern, contains, domain, pass, passes, ip, user, hash, phone, or raw query must be supplied")
+	}
+
+	query := elastic.NewBoolQuery()
+	if len(shouldQueries) > 0 {
+		query = query.Should(shouldQueries...)
+		if q.MinShouldMatch != "" {
+			query = query.MinimumShouldMatch(q.MinShouldMatch)
+		} else {
+			query = query.MinimumNumberShouldMatch(1)
+		}
+	} else {
+		if q.MinShouldMatch != "" {
+			c.Warnings = append(c.Warnings, "min-should-match only applies to multi-term searches, ignoring")
+		}
+		if termQuery == nil {
+			termQuery = elastic.NewQueryStringQuery(c.String)
+		}
+		query = query.Must(termQuery)
+	}
+	// date range on the breach timestamp
+	if q.After != "" || q.Before != "" {
+		dateField := q.DateField
+		if dateField == "" {
+			dateField = "breach_date"
+		}
+		dateRange := elastic.NewRangeQuery(dateField)
+		if q.After != "" {
+			dateRange = dateRange.Gt(q.After)
+		}
+		if q.Before != "" {
+			dateRange = dateRange.Lt(q.Before)
+		}
+		query = query.Filter(dateRange)
+	}
+	// network block on the IP field, mapped as ip or keyword like an IP search
+	if q.CIDR != "" {
+		cidrQuery, err := ipQuery(ctx, client, strings.Join(indices, ","), ipField, q.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Filter(cidrQuery)
+		c.String += fmt.Sprintf(" AND %s:%s", ipField, q.CIDR)
+	}
+	c.Query = query
+	return c, nil
+}
+
+// emailPatterns returns the wildcard queries on the email field for pattern, a
+// local part pattern when it has no @, in each of domains or any domain. The *
+// and ? wildcards of pattern are kept, everything else is matched literally.
+func emailPatterns(pattern string, domains []string) ([]string, error) {
+	pattern = strings.ToLower(strings.Replace(pattern, `\`, `\\`, -1))
+	if strings.Contains(pattern, "@") {
+		if len(domains) > 0 {
+			return nil, errors.New("an email pattern combined with a domain must not contain @")
+		}
+		return []string{pattern}, nil
+	} else if len(domains) == 0 {
+		return []string{pattern + "@*"}, nil
+	}
+	patterns := make([]string, len(domains))
+	for i, domain := range domains {
+		patterns[i] = pattern + "@" + wildcardEscape(strings.ToLower(domain))
+	}
+	return patterns, nil
+}
+
+// SplitList splits a comma-separated list, dropping empty items
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
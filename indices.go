@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// validIndexPattern checks one entry of a comma-separated index list, an
+// index name, alias or wildcard pattern, optionally prefixed with - to exclude it
+func validIndexPattern(pattern string) error {
+	name := strings.TrimPrefix(pattern, "-")
+	if name == "" {
+		return errors.New("empty index name")
+	} else if strings.ContainsAny(name, ` "\/<>|#`) {
+		return fmt.Errorf("index %q contains an invalid character", pattern)
+	} else if strings.ToLower(name) != name {
+		return fmt.Errorf("index %q must be lowercase", pattern)
+	} else if strings.HasPrefix(name, "_") || strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("index %q cannot start with _, + or -", pattern)
+	}
+	return nil
+}
+
+// isSecurityException reports whether err is an authorization failure
+func isSecurityException(err error) bool {
+	e, ok := err.(*elastic.Error)
+	if !ok || e.Details == nil {
+		return false
+	}
+	if e.Details.Type == "security_exception" {
+		return true
+	}
+	for _, cause := range e.Details.RootCause {
+		if cause.Type == "security_exception" {
+			return true
+		}
+	}
+	return false
+}
+
+// readableIndices expands pattern to concrete indices and probes each one,
+// splitting them into those the credentials can search and those they cannot
+func readableIndices(ctx context.Context, client *elastic.Client, pattern string) (readable, denied []string, err error) {
+	names := hoardd.SplitList(pattern)
+	if strings.ContainsAny(pattern, "*?") || strings.Contains(","+pattern, ",-") {
+		// the cluster applies the exclusions while expanding the whole list
+		rows, err := client.CatIndices().Index(strings.Join(names, ",")).Columns("index").Do(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot expand %s to check index permissions: %s", pattern, err)
+		}
+		names = names[:0]
+		for _, row := range rows {
+			names = append(names, row.Index)
+		}
+	}
+	for _, name := range names {
+		_, err := client.Count(name).Do(ctx)
+		if isSecurityException(err) {
+			denied = append(denied, name)
+		} else if err != nil {
+			return nil, nil, err
+		} else {
+			readable = append(readable, name)
+		}
+	}
+	return readable, denied, nil
+}
+
+// indexMatches reports whether part of an index pattern names an existing
+// index or alias, or expands to at least one index for a wildcard
+func indexMatches(ctx context.Context, client *elastic.Client, part string) (bool, error) {
+	if !strings.ContainsAny(part, "*?") {
+		return client.IndexExists(part).Do(ctx)
+	}
+	rows, err := client.CatIndices().Index(part).Columns("index").Do(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// checkIndex fails with the available leak_* indices when nothing in pattern
+// matches an existing index
+func checkIndex(ctx context.Context, client *elastic.Client, pattern string) error {
+	var missing []string
+	for _, part := range hoardd.SplitList(pattern) {
+		if strings.HasPrefix(part, "-") {
+			// exclusions don't need to match anything
+			continue
+		}
+		ok, err := indexMatches(ctx, client, part)
+		if isSecurityException(err) {
+			// without monitor privileges the permission check reports the problem
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cannot check index %s: %s", part, err)
+		}
+		if !ok {
+			missing = append(missing, part)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	rows, err := client.CatIndices().Index("leak_*").Columns("index").Sort("index").Do(ctx)
+	if err != nil || len(rows) == 0 {
+		return fmt.Errorf("no index matches %s", strings.Join(missing, ", "))
+	}
+	available := make([]string, len(rows))
+	for i, row := range rows {
+		available[i] = row.Index
+	}
+	return fmt.Errorf("no index matches %s, available indices: %s", strings.Join(missing, ", "), strings.Join(available, ", "))
+}
+
+// default index.max_result_window of Elasticsearch
+const defaultMaxResultWindow = 10000
+
+// maxResultWindow returns the smallest index.max_result_window of the
+// indices matching pattern, which caps the size of every scroll batch
+func maxResultWindow(ctx context.Context, client *elastic.Client, pattern string) (window int, index string, err error) {
+	res, err := client.IndexGetSettings(hoardd.SplitList(pattern)...).Name("index.max_result_window").FlatSettings(true).Do(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	for name, settings := range res {
+		limit := defaultMaxResultWindow
+		if settings != nil {
+			// flat settings are returned as strings
+			if v, ok := settings.Settings["index.max_result_window"].(string); ok {
+				if n, err := strconv.Atoi(v); err == nil {
+					limit = n
+				}
+			}
+		}
+		if index == "" || limit < window {
+			window, index = limit, name
+		}
+	}
+	return window, index, nil
+}
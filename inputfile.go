@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// search fields a term from an input file can map to
+var inputTypes = map[string]bool{"domain": true, "email": true, "pass": true, "ip": true, "user": true, "hash": true, "phone": true}
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// loadSearchTerms reads one search term per line, skipping blank lines and
+// lines starting with #. Gzipped files are decompressed as they are read.
+func loadSearchTerms(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// sniffed rather than going by the name, so renamed lists work too
+	in := bufio.NewReader(f)
+	var r io.Reader = in
+	if magic, _ := in.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var terms []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		term := strings.TrimSpace(scanner.Text())
+		if term == "" || strings.HasPrefix(term, "#") {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%s lists no search terms", path)
+	}
+	return terms, nil
+}
+
+// validateTerm rejects terms that cannot be searched as the given input type
+func validateTerm(inputType, term string) error {
+	if inputType != "pass" && inputType != "user" && inputType != "phone" && strings.ContainsAny(term, " \t") {
+		return errors.New("contains whitespace")
+	}
+	switch inputType {
+	case "email":
+		if at := strings.LastIndex(term, "@"); at < 1 || at == len(term)-1 {
+			return errors.New("not an email address")
+		}
+	case "domain":
+		if strings.Contains(term, "@") {
+			return errors.New("not a domain")
+		}
+	case "ip":
+		if _, err := hoardd.ParseIPSearch(term); err != nil {
+			return err
+		}
+	case "phone":
+		if _, err := hoardd.NormalizePhone(term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSearchTerm sets the search parameter of the given input type to term
+func setSearchTerm(cfg *Config, inputType, term string) {
+	switch inputType {
+	case "domain":
+		cfg.Domain = term
+	case "email":
+		cfg.Email = term
+	case "pass":
+		cfg.Pass = term
+	case "ip":
+		cfg.IP = term
+	case "user":
+		cfg.User = term
+	case "hash":
+		cfg.Hash = term
+	case "phone":
+		cfg.Phone = term
+	}
+}
+
+// runInputFile searches every term as the given input type, up to workers
+// at a time over the shared client, appending all results to cfg.Outfile
+// with a search_term column. Malformed terms are skipped with a warning. It
+// returns the number of failed searches after logging a summary of every
+// term.
+func runInputFile(ctx context.Context, client *elastic.Client, cfg Config, inputType string, terms []string, workers int) int {
+	fileOutput := cfg.ReindexTo == "" && cfg.SQLite == "" && !cfg.CountOnly && !cfg.Summary && !cfg.ListFields && cfg.Sample == 0
+	// every search appends to out, a temporary file next to a fresh outfile
+	// that replaces it once the run ends, or the outfile itself with append
+	out := cfg.Outfile
+	var tmp *os.File
+	if fileOutput && !cfg.Append {
+		err := checkOverwrite(cfg.Outfile, cfg.Force)
+		if err == nil {
+			tmp, err = createOutput(cfg.Outfile)
+		}
+		if err != nil {
+			errorf("error creating %s: %s", cfg.Outfile, err)
+			return len(terms)
+		}
+		// removes nothing once committed
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		out = tmp.Name()
+	}
+	if workers > 1 {
+		// concurrent progress bars would draw over each other
+		cfg.NoProgress = true
+		debugf("searching %d terms concurrently", workers)
+	}
+	malformed := make([]error, len(terms))
+	searched := make([]bool, len(terms))
+	errs := make([]error, len(terms))
+	rows := make([]int, len(terms))
+	// appends to the outfile happen one term at a time
+	var outMu sync.Mutex
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, term := range terms {
+		if err := validateTerm(inputType, term); err != nil {
+			warnf("skipping malformed %s %q (term %d): %s", inputType, term, i+1, err)
+			malformed[i] = err
+			continue
+		}
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		debugf("term %d/%d: %s %s", i+1, len(terms), inputType, term)
+		searched[i] = true
+		wg.Add(1)
+		go func(i int, term string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rows[i], errs[i] = searchTerm(ctx, client, cfg, inputType, term, out, fileOutput, &outMu)
+		}(i, term)
+	}
+	wg.Wait()
+
+	matched, empty, skipped, failed, unsearched := 0, 0, 0, 0, 0
+	infof("term summary:")
+	for i, term := range terms {
+		var result string
+		switch err := errs[i]; {
+		case malformed[i] != nil:
+			result = "skipped, malformed: " + malformed[i].Error()
+			skipped++
+		case !searched[i]:
+			result = "not searched, " + stopError(ctx).Error()
+			unsearched++
+		case err == nil || err == errLimitReached || err == errInterrupted || err == errTimeout:
+			result = "ok"
+			if err != nil {
+				result = err.Error()
+			}
+			if fileOutput {
+				result += fmt.Sprintf(", %d results", rows[i])
+			}
+			matched++
+		case err == errNoResults:
+			result = "no results"
+			empty++
+		default:
+			result = "failed: " + err.Error()
+			failed++
+		}
+		infof("  %d. %s %s: %s", i+1, inputType, term, result)
+	}
+	if unsearched > 0 {
+		infof("%s, %d of %d terms not searched", stopError(ctx), unsearched, len(terms))
+	}
+	infof("input summary: %d terms, %d with results, %d without, %d malformed, %d failed",
+		len(terms), matched, empty, skipped, failed)
+	// like a fresh export, an interrupted run is discarded and a timed out
+	// one keeps the results of the terms searched so far
+	if tmp != nil && ctx.Err() != nil && stopError(ctx) == errInterrupted {
+		infof("interrupted, discarded the results of %d terms", matched)
+	} else if tmp != nil {
+		if err := commitOutput(tmp, cfg.Outfile); err != nil {
+			errorf("error writing %s: %s", cfg.Outfile, err)
+			return len(terms)
+		}
+	}
+	return failed
+}
+
+// searchTerm exports the results of a single input file term. File output
+// goes to a part file next to the outfile first, which is appended to out
+// under outMu once the search ends, so the rows of concurrent terms never
+// interleave. It returns the number of rows appended.
+func searchTerm(ctx context.Context, client *elastic.Client, cfg Config, inputType, term, out string, fileOutput bool, outMu *sync.Mutex) (int, error) {
+	termCfg := cfg
+	setSearchTerm(&termCfg, inputType, term)
+	termCfg.SearchTerm = term
+	termCfg.Append = true
+	// terms are searched one slice each, the workers run terms instead
+	termCfg.Workers = 1
+	if !fileOutput {
+		return 0, export(ctx, client, termCfg)
+	}
+	part, err := ioutil.TempFile(filepath.Dir(cfg.Outfile), "."+filepath.Base(cfg.Outfile)+".*.part")
+	if err != nil {
+		return 0, err
+	}
+	part.Close()
+	defer os.Remove(part.Name())
+	termCfg.Outfile = part.Name()
+	// parts stay uncompressed, the append compresses them
+	termCfg.Gzip = false
+	err = export(ctx, client, termCfg)
+	switch err {
+	case nil, errLimitReached, errInterrupted, errTimeout:
+		// interrupted searches keep the rows written so far
+	default:
+		return 0, err
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	rows, appendErr := appendPart(out, part.Name(), cfg.Format == "csv", cfg.Gzip)
+	if appendErr != nil {
+		return rows, fmt.Errorf("error appending results to %s: %s", cfg.Outfile, appendErr)
+	}
+	return rows, err
+}
+
+// appendPart appends the rows of part to outfile, with the header line of
+// part only while outfile is empty when hasHeader, and as a new gzip member
+// when compress. It returns the number of rows appended.
+func appendPart(outfile, part string, hasHeader, compress bool) (int, error) {
+	in, err := os.Open(part)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	// headers never contain line breaks, column names are validated
+	header := ""
+	if hasHeader {
+		if header, err = r.ReadString('\n'); err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		// nothing to append for a search without results
+		return 0, nil
+	}
+	f, err := os.OpenFile(outfile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var out io.Writer = f
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(f)
+		out = gz
+	}
+	if info.Size() == 0 && header != "" {
+		if _, err := io.WriteString(out, header); err != nil {
+			return 0, err
+		}
+	}
+	counter := &rowCounter{csv: hasHeader}
+	if _, err := io.Copy(io.MultiWriter(out, counter), r); err != nil {
+		return counter.rows, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return counter.rows, err
+		}
+	}
+	return counter.rows, f.Close()
+}
+
+// rowCounter counts the rows written through it, line breaks in quoted CSV
+// fields excluded
+type rowCounter struct {
+	csv    bool
+	quoted bool
+	rows   int
+}
+
+func (c *rowCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '"' && c.csv {
+			c.quoted = !c.quoted
+		} else if b == '\n' && !c.quoted {
+			c.rows++
+		}
+	}
+	return len(p), nil
+}
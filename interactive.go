@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/term"
+)
+
+// number of hits printed after each interactive count unless changed with
+// the sample command
+const interactiveSample = 5
+
+const interactiveHelp = `commands:
+  <type> <term>  count the results of a search, type is one of domain, email, pass, ip, user, hash, or phone
+  sample <n>     print up to n hits after each count, 0 prints counts only
+  help           print this help
+  quit           exit
+`
+
+// runInteractive reads one command per line from in and writes counts and
+// sample hits to out, reusing the connection of client for every search.
+// Failed searches are logged and the loop continues until quit or the end
+// of the input.
+func runInteractive(ctx context.Context, client *elastic.Client, cfg Config, in io.Reader, out io.Writer) error {
+	// only prompt a person, piped commands run silently
+	prompt := term.IsTerminal(int(os.Stdin.Fd()))
+	sample := interactiveSample
+	scanner := bufio.NewScanner(in)
+	for {
+		if ctx.Err() != nil {
+			return stopError(ctx)
+		}
+		if prompt {
+			fmt.Fprint(out, "> ")
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		command, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			command, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch {
+		case command == "quit" || command == "exit":
+			return nil
+		case command == "help":
+			fmt.Fprint(out, interactiveHelp)
+		case command == "sample":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				infof("sample must be a number of hits, 0 prints counts only")
+				continue
+			}
+			sample = n
+		case inputTypes[command]:
+			if arg == "" {
+				infof("%s needs a search term", command)
+			} else if err := validateTerm(command, arg); err != nil {
+				infof("malformed %s %q: %s", command, arg, err)
+			} else if err := interactiveSearch(ctx, client, cfg, command, arg, sample, out); err != nil {
+				if ctx.Err() != nil {
+					return stopError(ctx)
+				}
+				errorf("error searching %s %q: %s", command, arg, err)
+			}
+		default:
+			infof("unknown command %q, type help for the commands", command)
+		}
+	}
+}
+
+// interactiveSearch counts the results of searching term as the given input
+// type and prints up to sample of them as CSV, without scrolling
+func interactiveSearch(ctx context.Context, client *elastic.Client, cfg Config, inputType, term string, sample int, out io.Writer) error {
+	termCfg := cfg
+	setSearchTerm(&termCfg, inputType, term)
+	leakClient := hoardd.NewClient(client, cfg.Index)
+	leakClient.MinScore = cfg.MinScore
+	compiled, err := leakClient.Compile(ctx, buildQuery(termCfg))
+	if err != nil {
+		return err
+	}
+	for _, warning := range compiled.Warnings {
+		warnf("%s", warning)
+	}
+	total, err := leakClient.CountCompiled(ctx, compiled)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d results\n", total)
+	if sample == 0 || total == 0 {
+		return nil
+	}
+
+	ss := elastic.NewSearchSource().Query(compiled.Query).Size(sample)
+	if cfg.MinScore > 0 {
+		ss = ss.MinScore(cfg.MinScore)
+	}
+	search := client.Search(leakClient.Indices()...).SearchSource(ss)
+	if cfg.NoPasswordOutput {
+		search = search.FetchSourceContext(elastic.NewFetchSourceContext(true).Exclude("password"))
+	}
+	result, err := search.Do(ctx)
+	if err != nil {
+		return err
+	}
+	rows := rowFormat{
+		format:        "csv",
+		noPassword:    cfg.NoPasswordOutput,
+		maskPasswords: cfg.MaskPasswords,
+	}
+	fmt.Fprint(out, rows.header())
+	for _, hit := range result.Hits.Hits {
+		l, err := hoardd.DecodeLeak(hit.Source)
+		if err != nil {
+			warnf("skipping malformed hit %s: %s", hit.Id, err)
+			continue
+		}
+		fmt.Fprint(out, rows.row(l, hit))
+	}
+	return nil
+}
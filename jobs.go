@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"gopkg.in/yaml.v2"
+)
+
+// Job definition from the YAML jobs file, each job is one search with its
+// own output. Every other setting is shared from the config and flags.
+type Job struct {
+	Name    string `yaml:"name"`
+	Domain  string `yaml:"domain"`
+	Email   string `yaml:"email"`
+	Pass    string `yaml:"pass"`
+	IP      string `yaml:"ip"`
+	User    string `yaml:"user"`
+	Hash    string `yaml:"hash"`
+	Phone   string `yaml:"phone"`
+	Outfile string `yaml:"outfile"`
+}
+
+// jobsFile is the top-level structure of the YAML jobs file
+type jobsFile struct {
+	Jobs []Job `yaml:"jobs"`
+}
+
+// loadJobs reads and validates a jobs file
+func loadJobs(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var file jobsFile
+	if err := yaml.NewDecoder(f).Decode(&file); err != nil {
+		return nil, err
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("%s lists no jobs", path)
+	}
+	return file.Jobs, nil
+}
+
+// validate checks that a job searches exactly one of domain, email, pass, ip,
+// user, hash, or phone, and names its outfile when one is needed
+func (j Job) validate(needOutfile bool) error {
+	set := setSearchParams(Config{Domain: j.Domain, Email: j.Email, Pass: j.Pass, IP: j.IP, User: j.User, Hash: j.Hash, Phone: j.Phone})
+	if len(set) == 0 {
+		return fmt.Errorf("exactly one of domain, email, pass, ip, user, hash, or phone must be set")
+	} else if len(set) > 1 {
+		return fmt.Errorf("exactly one of domain, email, pass, ip, user, hash, or phone must be set, not %s", strings.Join(set, " and "))
+	} else if needOutfile && j.Outfile == "" {
+		return fmt.Errorf("outfile must be set")
+	}
+	return nil
+}
+
+func (j Job) String() string {
+	if j.Name != "" {
+		return j.Name
+	} else if j.Domain != "" {
+		return "domain " + j.Domain
+	} else if j.Email != "" {
+		return "email " + j.Email
+	} else if j.IP != "" {
+		return "ip " + j.IP
+	} else if j.User != "" {
+		return "user " + j.User
+	} else if j.Hash != "" {
+		return "hash " + j.Hash
+	} else if j.Phone != "" {
+		return "phone " + j.Phone
+	}
+	return "pass " + j.Pass
+}
+
+// runJobs runs every job in sequence on the shared client, isolating failures,
+// and returns the number of jobs that failed after logging a summary
+func runJobs(ctx context.Context, client *elastic.Client, cfg Config, jobs []Job) int {
+	results := make([]string, len(jobs))
+	failed := 0
+	for i, job := range jobs {
+		if ctx.Err() != nil {
+			results[i] = "not run, " + stopError(ctx).Error()
+			continue
+		}
+		infof("job %d/%d: %s", i+1, len(jobs), job)
+		err := job.validate(cfg.ReindexTo == "" && cfg.SQLite == "")
+		if err == nil {
+			jobCfg := cfg
+			jobCfg.Domain, jobCfg.Email, jobCfg.Pass, jobCfg.IP = job.Domain, job.Email, job.Pass, job.IP
+			jobCfg.User, jobCfg.Hash, jobCfg.Phone = job.User, job.Hash, job.Phone
+			jobCfg.Outfile = job.Outfile
+			err = export(ctx, client, jobCfg)
+		}
+		switch err {
+		case nil:
+			results[i] = "ok"
+		case errLimitReached:
+			results[i] = fmt.Sprintf("ok, limit of %d results reached", cfg.Limit)
+		case errNoResults:
+			// an empty search is a result, exit 5 is for single searches
+			results[i] = "ok, no results"
+		case errInterrupted, errTimeout:
+			results[i] = err.Error()
+		default:
+			results[i] = "failed: " + err.Error()
+			failed++
+		}
+	}
+	infof("job summary:")
+	for i, job := range jobs {
+		infof("  %d. %s: %s", i+1, job, results[i])
+	}
+	return failed
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// logLevel is the severity of a log message, messages below the level set by
+// -log-level are dropped
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevels are the -log-level names, from the most to the least verbose
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// minLevel is the lowest level logged
+var minLevel = levelInfo
+
+// parseLogLevel returns the level named by name
+func parseLogLevel(name string) (logLevel, error) {
+	for i, level := range logLevels {
+		if strings.EqualFold(name, level) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, must be one of %s", name, strings.Join(logLevels, ", "))
+}
+
+// logf writes a message to the standard logger, which writes to stderr, if
+// level is enabled. Warnings keep their "warning:" prefix.
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if level == levelWarn {
+		msg = "warning: " + msg
+	}
+	log.Print(msg)
+}
+
+// debugf logs details for troubleshooting, shown with -verbose or -debug
+func debugf(format string, args ...interface{}) {
+	logf(levelDebug, format, args...)
+}
+
+// infof logs the progress and results of a run
+func infof(format string, args ...interface{}) {
+	logf(levelInfo, format, args...)
+}
+
+// warnf logs problems the run continues past
+func warnf(format string, args ...interface{}) {
+	logf(levelWarn, format, args...)
+}
+
+// errorf logs failures
+func errorf(format string, args ...interface{}) {
+	logf(levelError, format, args...)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// exportOutput is where an export writes its results: a reindex target, a
+// sqlite database, or an outfile with its layers of encryption and
+// compression, split into parts and paired with a side file on request
+type exportOutput struct {
+	cfg     Config
+	outfile string
+	bulk    *elastic.BulkProcessor
+	db      *sqliteWriter
+	f       *os.File
+	// out writes to f through the layers
+	out io.Writer
+	// writers layered on f, closed outermost first to finalize their streams
+	layers []io.Closer
+	// appended output already has a header unless the file is still empty
+	writeHeader bool
+	// temporary file of a fresh export, removed unless the export completes
+	tmp string
+	// current part of a split export, 0 when not split
+	part int
+	// side file of the optional leak fields, for -enrich-outfile
+	side *enrichWriter
+	// cache entry stored once the export completes, empty for partial exports
+	cacheID string
+	// checkpoint of a checkpointed export, continued from an earlier run
+	// when it holds a scroll id
+	cp *checkpoint
+}
+
+// openOutput sets up the output of an export of query for cfg, connecting to
+// the reindex target, opening the sqlite database, or creating the outfile
+// unless it would replace earlier results. It returns nil without an error
+// when the cache already held the results and copied them to the outfile.
+func openOutput(ctx context.Context, client *elastic.Client, cfg Config, rows *rowFormat, query string) (*exportOutput, error) {
+	o := &exportOutput{cfg: cfg, outfile: cfg.Outfile, writeHeader: true}
+	if cfg.ReindexTo != "" {
+		target := client
+		if cfg.ReindexURL != "" {
+			_, err := url.ParseRequestURI(cfg.ReindexURL)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing reindex-url parameter: %s", cfg.ReindexURL)
+			}
+			targetCfg := cfg
+			targetCfg.InputURL = cfg.ReindexURL
+			target, err = connect(targetCfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		o.bulk, err = target.BulkProcessor().Name("reindex").Workers(2).BulkActions(1000).
+			FlushInterval(time.Second).Stats(true).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		infof("reindexing matches into %s", cfg.ReindexTo)
+		return o, nil
+	} else if cfg.SQLite != "" {
+		// rows are inserted into the table instead of written
+		var err error
+		o.db, err = openSQLite(cfg.SQLite, rows.columns())
+		if err != nil {
+			return nil, fmt.Errorf("error opening sqlite database %s: %s", cfg.SQLite, err)
+		}
+		debugf("inserting results into the %s table of %s", sqliteTable, cfg.SQLite)
+		return o, nil
+	}
+
+	// auto file output
+	if o.outfile == "" {
+		o.outfile = autoOutfile(cfg)
+		warnf("no outfile specified, automatically generating one: %s", o.outfile)
+	}
+	outfile := o.outfile
+
+	// earlier results are only replaced on request, resumed and
+	// checkpointed exports write to their outfile on purpose
+	if outfile != "-" && !cfg.Append && cfg.ResumeFromLine == 0 && cfg.Checkpoint == "" {
+		first := outfile
+		if cfg.Split > 0 {
+			first = splitName(outfile, 1)
+		}
+		if err := checkOverwrite(first, cfg.Force); err != nil {
+			return nil, err
+		}
+	}
+
+	// identical repeat queries are served from the cache
+	if cfg.CacheDir != "" && cfg.SamplePerIndex == 0 && cfg.ResumeFromLine == 0 && !cfg.Append {
+		o.cacheID = exportCacheKey(cfg, query)
+		cached, err := loadCache(cfg.CacheDir, o.cacheID, outfile, cfg.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		if cached {
+			infof("cache hit, copied cached results to %s", outfile)
+			return nil, nil
+		}
+		debugf("cache miss, results will be cached in %s", cfg.CacheDir)
+	}
+
+	// continue from the checkpoint of an earlier run of the same export
+	if cfg.Checkpoint != "" {
+		key := cacheKey(cfg.InputURL, cfg.Index, query, cfg.Sort, outfile, cfg.Format, cfg.EncodeFields)
+		cp, err := loadCheckpoint(cfg.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && cp.Key != key {
+			return nil, fmt.Errorf("checkpoint %s belongs to a different query, sort or outfile, remove it to start over", cfg.Checkpoint)
+		} else if cp != nil {
+			// drop any rows written after the checkpoint
+			if err := os.Truncate(outfile, cp.Offset); err != nil {
+				return nil, err
+			}
+			infof("continuing %s from checkpoint after %d results", outfile, cp.Rows)
+		} else if err := checkOverwrite(outfile, cfg.Force); err != nil {
+			// a new checkpointed export starts from an empty outfile
+			return nil, err
+		} else {
+			cp = &checkpoint{Key: key}
+		}
+		o.cp = cp
+	}
+
+	// split exports number every file, starting with the first
+	if cfg.Split > 0 {
+		o.part = 1
+	}
+	if err := o.create(rows); err != nil {
+		o.close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// create opens the outfile, and the side file, as the export mode demands
+func (o *exportOutput) create(rows *rowFormat) error {
+	cfg, outfile, cp := o.cfg, o.outfile, o.cp
+	var err error
+	// check path exists/file create permissions
+	if outfile == "-" {
+		// stdout carries only the export, logs and the progress bar go to stderr
+		o.f = os.Stdout
+	} else if cfg.ResumeFromLine > 0 {
+		o.f, err = os.OpenFile(outfile, os.O_WRONLY|os.O_APPEND, 0)
+		if err == nil {
+			infof("resuming %s after row %d", outfile, cfg.ResumeFromLine)
+		}
+	} else if cfg.Append || cp != nil && cp.ScrollID != "" {
+		o.f, err = os.OpenFile(outfile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	} else if cp != nil {
+		// a checkpointed export is continued in place after a failure
+		o.f, err = os.Create(outfile)
+	} else if o.part > 0 {
+		o.f, err = createOutput(splitName(outfile, o.part))
+	} else {
+		o.f, err = createOutput(outfile)
+	}
+	if err != nil {
+		o.f = nil
+		return err
+	}
+	if o.f != os.Stdout && cfg.ResumeFromLine == 0 && !cfg.Append && cp == nil {
+		o.tmp = o.f.Name()
+	}
+	// the optional leak fields move to the side file, keyed by row number
+	if cfg.EnrichOutfile != "" {
+		if o.side, err = createEnrich(cfg.EnrichOutfile, rows, rows.fields, cfg.Force); err != nil {
+			return err
+		}
+		debugf("writing %s to %s", strings.Join(rows.fields, ", "), cfg.EnrichOutfile)
+		rows.fields, rows.rowNumbers = nil, true
+	}
+	if cfg.ResumeFromLine > 0 || cfg.Append || cp != nil {
+		info, err := o.f.Stat()
+		if err != nil {
+			return err
+		}
+		o.writeHeader = info.Size() == 0
+	}
+	return o.wrap()
+}
+
+// wrap layers encryption and compression on f
+func (o *exportOutput) wrap() error {
+	o.out = o.f
+	// everything written to out is encrypted before reaching the disk
+	if o.cfg.Encrypt {
+		enc, err := encryptWriter(o.out, o.cfg.Passphrase, o.cfg.Recipients)
+		if err != nil {
+			return err
+		}
+		o.layers = append(o.layers, enc)
+		o.out = enc
+	}
+	// compression sits above encryption, encrypted data doesn't compress
+	if o.cfg.Gzip {
+		gz := gzip.NewWriter(o.out)
+		o.layers = append(o.layers, gz)
+		o.out = gz
+	}
+	return nil
+}
+
+// closeLayers finalizes the compression and encryption streams on f
+func (o *exportOutput) closeLayers() error {
+	for len(o.layers) > 0 {
+		layer := o.layers[len(o.layers)-1]
+		o.layers = o.layers[:len(o.layers)-1]
+		if err := layer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit renames the temporary file of the current part, and the side file,
+// into place
+func (o *exportOutput) commit() error {
+	if o.side != nil {
+		if err := o.side.commit(o.cfg.EnrichOutfile); err != nil {
+			return err
+		}
+		o.side = nil
+	}
+	if o.tmp == "" {
+		return nil
+	}
+	name := o.outfile
+	if o.part > 0 {
+		name = splitName(o.outfile, o.part)
+	}
+	if err := commitOutput(o.f, name); err != nil {
+		return err
+	}
+	o.tmp = ""
+	return nil
+}
+
+// nextPart commits the current part of a split export and creates the next,
+// which the caller starts with a header
+func (o *exportOutput) nextPart() error {
+	if err := o.closeLayers(); err != nil {
+		return err
+	}
+	if err := o.commit(); err != nil {
+		return err
+	}
+	o.part++
+	name := splitName(o.outfile, o.part)
+	if err := checkOverwrite(name, o.cfg.Force); err != nil {
+		return err
+	}
+	f, err := createOutput(name)
+	if err != nil {
+		return err
+	}
+	o.f, o.tmp = f, f.Name()
+	debugf("writing part %d to %s", o.part, name)
+	return o.wrap()
+}
+
+// finish closes the sqlite database, finalizes the layers on f and flushes
+// the reindex target
+func (o *exportOutput) finish() error {
+	if o.db != nil {
+		if err := o.db.Close(); err != nil {
+			return err
+		}
+	}
+	if err := o.closeLayers(); err != nil {
+		return err
+	}
+	if o.bulk != nil {
+		return closeReindex(o.bulk, o.cfg.ReindexTo)
+	}
+	return nil
+}
+
+// close releases whatever the export left open, discarding the temporary
+// file and the side file of an export that did not complete
+func (o *exportOutput) close() {
+	o.closeLayers()
+	if o.side != nil {
+		o.side.discard()
+	}
+	if o.f != nil && o.f != os.Stdout {
+		o.f.Close()
+	}
+	if o.db != nil {
+		o.db.Close()
+	}
+	if o.tmp != "" {
+		os.Remove(o.tmp)
+	}
+}
+
+// dest describes where the results went, for the logs
+func (o *exportOutput) dest() string {
+	if o.db != nil {
+		return o.cfg.SQLite
+	} else if o.outfile == "-" {
+		return "stdout"
+	} else if o.part > 0 {
+		return fmt.Sprintf("%s to %s", splitName(o.outfile, 1), splitName(o.outfile, o.part))
+	}
+	return o.outfile
+}
+
+// writeHits writes the complete output of a search answered by a single
+// request and returns the number of rows written
+func (o *exportOutput) writeHits(rows *rowFormat, hits []*elastic.SearchHit) (int, error) {
+	w := bufio.NewWriter(o.out)
+	if o.writeHeader {
+		if _, err := w.WriteString(rows.header()); err != nil {
+			return 0, err
+		}
+	}
+	written := 0
+	for _, hit := range hits {
+		l, err := hoardd.DecodeLeak(hit.Source)
+		if err != nil {
+			warnf("skipping malformed hit %s: %s", hit.Id, err)
+			continue
+		}
+		if _, err := w.WriteString(rows.row(l, hit)); err != nil {
+			return written, err
+		}
+		if o.side != nil {
+			if err := o.side.write(l, hit); err != nil {
+				return written, err
+			}
+		}
+		written++
+	}
+	if _, err := w.WriteString(rows.footer()); err != nil {
+		return written, err
+	}
+	if err := w.Flush(); err != nil {
+		return written, err
+	}
+	if err := o.closeLayers(); err != nil {
+		return written, err
+	}
+	return written, o.commit()
+}
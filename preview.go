@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// maxSample is the largest -sample, the default max_result_window of an index
+const maxSample = 10000
+
+// sampleHit is a hit as printed by -sample
+type sampleHit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  *float64        `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// printSample writes hits as indented JSON documents separated by blank
+// lines, masking the password of every source when mask is set
+func printSample(w io.Writer, hits []*elastic.SearchHit, mask bool) {
+	for i, hit := range hits {
+		source := hit.Source
+		if len(source) == 0 {
+			source = json.RawMessage("null")
+		} else if mask {
+			var doc map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(source))
+			// keep numbers exactly as indexed
+			decoder.UseNumber()
+			if err := decoder.Decode(&doc); err == nil && doc != nil {
+				if password, ok := doc["password"].(string); ok {
+					doc["password"] = maskPassword(password)
+				}
+				// decoded JSON always marshals
+				source, _ = json.Marshal(doc)
+			}
+		}
+		data, err := json.MarshalIndent(sampleHit{Index: hit.Index, ID: hit.Id, Score: hit.Score, Source: source}, "", "  ")
+		if err != nil {
+			// a malformed source cannot be embedded, print it as is
+			data = []byte(fmt.Sprintf("%s %s: %s", hit.Index, hit.Id, hit.Source))
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n", data)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// scrollRetries is the number of times a failed batch request is re-issued,
+// waiting scrollRetryWait before the first retry and doubling it after each
+const (
+	scrollRetries   = 3
+	scrollRetryWait = 2 * time.Second
+)
+
+// scrollReconnects is the number of times an export reconnects to continue a
+// lost scroll before giving up, counted from the last successful batch
+const scrollReconnects = 3
+
+// scrollUnavailable reports whether err means the cluster refuses scrolls,
+// because they are disabled or the page exceeds the result window
+func scrollUnavailable(err error) bool {
+	e, ok := err.(*elastic.Error)
+	if !ok || e.Details == nil {
+		return false
+	}
+	reasons := []string{e.Details.Reason}
+	for _, cause := range e.Details.RootCause {
+		reasons = append(reasons, cause.Reason)
+	}
+	for _, reason := range reasons {
+		reason = strings.ToLower(reason)
+		if strings.Contains(reason, "result window") || strings.Contains(reason, "scroll") {
+			return true
+		}
+	}
+	return false
+}
+
+// scrollLost reports whether err means the cluster no longer knows the scroll
+// context, because it expired or its node restarted
+func scrollLost(err error) bool {
+	e, ok := err.(*elastic.Error)
+	if !ok || e.Details == nil {
+		return false
+	}
+	details := append([]*elastic.ErrorDetails{e.Details}, e.Details.RootCause...)
+	for _, d := range details {
+		if d != nil && d.Type == "search_context_missing_exception" {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether a failed batch request may succeed when re-issued
+func retryable(err error) bool {
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		if elastic.IsStatusCode(err, code) {
+			return true
+		}
+	}
+	// i.e. a connection reset by a proxy or a load balancer mid-response,
+	// certificate errors are not network errors and fail fast
+	var opErr *net.OpError
+	return elastic.IsConnErr(err) || elastic.IsTimeout(err) || errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// doWithRetry runs a batch request, re-issuing it with backoff on retryable
+// errors. io.EOF ends a scroll and is returned right away.
+func doWithRetry(ctx context.Context, do func(ctx context.Context) (*elastic.SearchResult, error)) (*elastic.SearchResult, error) {
+	wait := scrollRetryWait
+	for attempt := 0; ; attempt++ {
+		result, err := do(ctx)
+		if err == nil || err == io.EOF || attempt == scrollRetries || ctx.Err() != nil || !retryable(err) {
+			return result, err
+		}
+		warnf("batch request failed: %s, retrying in %s", err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// scrollBatch is one page of hits from a scroll, or the error that ended it
+type scrollBatch struct {
+	result *elastic.SearchResult
+	took   time.Duration
+	err    error
+	// after holds the sort values of the last hit of the page, to continue
+	// with search_after from
+	after []interface{}
+}
+
+// newBatch returns the batch of a page fetched since start
+func newBatch(result *elastic.SearchResult, start time.Time, err error) scrollBatch {
+	batch := scrollBatch{result: result, took: time.Since(start), err: err}
+	if err == nil && result.Hits != nil && len(result.Hits.Hits) > 0 {
+		batch.after = result.Hits.Hits[len(result.Hits.Hits)-1].Sort
+	}
+	return batch
+}
+
+// closeBatches closes batches once its producers are done. A cancelled ctx is
+// sent as a last error first, so a stopped export is never taken for a
+// complete one; consumers that stop reading early drain the channel.
+func closeBatches(ctx context.Context, batches chan<- scrollBatch) {
+	if err := ctx.Err(); err != nil {
+		batches <- scrollBatch{err: err}
+	}
+	close(batches)
+}
+
+// drainBatches discards the remaining pages of a channel no longer read,
+// letting its producers finish
+func drainBatches(batches <-chan scrollBatch) {
+	for range batches {
+	}
+}
+
+// scrollSlices runs slices concurrent scrolls, each over its own sliced part
+// of the results, and sends every page on the returned channel. The channel
+// is closed once all slices are exhausted, or after the error of a cancelled
+// ctx. An error ends its slice after being sent. newScroll must return a fresh scroll on every
+// call, since slicing modifies its search source.
+func scrollSlices(ctx context.Context, newScroll func() *elastic.ScrollService, slices int) <-chan scrollBatch {
+	batches := make(chan scrollBatch)
+	var wg sync.WaitGroup
+	for i := 0; i < slices; i++ {
+		q := newScroll()
+		if slices > 1 {
+			q = q.Slice(elastic.NewSliceQuery().Id(i).Max(slices))
+		}
+		wg.Add(1)
+		go func(q *elastic.ScrollService) {
+			defer wg.Done()
+			for {
+				start := time.Now()
+				result, err := doWithRetry(ctx, q.Do)
+				if err == io.EOF {
+					return
+				}
+				select {
+				case batches <- newBatch(result, start, err):
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(q)
+	}
+	go func() {
+		wg.Wait()
+		closeBatches(ctx, batches)
+	}()
+	return batches
+}
+
+// searchAfterPages pages through the results with search_after, starting
+// after the sort values in after or at the first result when nil, and sends
+// every page on the returned channel. The channel is closed after the last
+// page, an error, or the error of a cancelled ctx.
+func searchAfterPages(ctx context.Context, searchAfter func(after []interface{}) *elastic.SearchService, after []interface{}) <-chan scrollBatch {
+	batches := make(chan scrollBatch)
+	go func() {
+		defer closeBatches(ctx, batches)
+		for {
+			start := time.Now()
+			result, err := doWithRetry(ctx, searchAfter(after).Do)
+			if err == nil && (result.Hits == nil || len(result.Hits.Hits) == 0) {
+				return
+			}
+			batch := newBatch(result, start, err)
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			after = batch.after
+		}
+	}()
+	return batches
+}
+
+// pageSource delivers the pages of an export. It scrolls, in slices with
+// workers, falls back to search_after when the cluster refuses scrolls, and
+// continues a lost scroll on a fresh connection from where the last written
+// page ended.
+type pageSource struct {
+	cfg         Config
+	client      *elastic.Client
+	indices     []string
+	source      func() *elastic.SearchSource
+	fetchSource *elastic.FetchSourceContext
+	// the producers of batches run until ctx is cancelled by stop
+	ctx     context.Context
+	stop    context.CancelFunc
+	batches <-chan scrollBatch
+	// where the last written page ended. A checkpointed export keeps it in the
+	// checkpoint, so the file follows a reconnected scroll.
+	position *checkpoint
+	// checkpointed exports never fall back, their checkpoint holds a scroll
+	checkpointed bool
+	// set once a page was delivered, falling back then would repeat rows
+	started    bool
+	reconnects int
+}
+
+// newPageSource starts paging through the results of source, continuing
+// the checkpoint cp when it holds a scroll
+func newPageSource(ctx context.Context, client *elastic.Client, cfg Config, indices []string,
+	source func() *elastic.SearchSource, fetchSource *elastic.FetchSourceContext, cp *checkpoint) *pageSource {
+	p := &pageSource{cfg: cfg, client: client, indices: indices, source: source, fetchSource: fetchSource,
+		position: cp, checkpointed: cp != nil}
+	if p.position == nil {
+		p.position = &checkpoint{}
+	}
+	p.ctx, p.stop = context.WithCancel(ctx)
+	if cfg.Workers > 1 {
+		debugf("scrolling %d slices concurrently", cfg.Workers)
+	}
+	if cp != nil && cp.ScrollID != "" {
+		p.batches = checkpointPages(p.ctx, cp.ScrollID, cp.SearchAfter, p.continueScroll, p.searchAfter)
+	} else {
+		debugf("paginating with scroll")
+		p.batches = scrollSlices(p.ctx, p.newScroll, cfg.Workers)
+	}
+	return p
+}
+
+// newScroll returns a fresh scroll over the results, sorted exports break
+// ties on _id so search_after can continue them after a checkpoint or a lost
+// scroll
+func (p *pageSource) newScroll() *elastic.ScrollService {
+	keepAlive := fmt.Sprintf("%ds", int64(p.cfg.ScrollKeepAlive/time.Second))
+	q := p.client.Scroll(p.indices...).KeepAlive(keepAlive).Size(p.cfg.ScrollSize).SearchSource(p.source())
+	if p.cfg.Sort != "" {
+		q = q.SortBy(elastic.NewFieldSort(p.cfg.Sort).Asc(), elastic.NewFieldSort("_id").Asc())
+	}
+	if p.fetchSource != nil {
+		q = q.FetchSourceContext(p.fetchSource)
+	}
+	return q
+}
+
+// continueScroll returns the scroll scrollID
+func (p *pageSource) continueScroll(scrollID string) *elastic.ScrollService {
+	return p.newScroll().ScrollId(scrollID)
+}
+
+// searchAfter returns the search of the page after the sort values in after,
+// paged on the sort plus _id to break ties
+func (p *pageSource) searchAfter(after []interface{}) *elastic.SearchService {
+	var sorters []elastic.Sorter
+	if p.cfg.Sort != "" {
+		sorters = append(sorters, elastic.NewFieldSort(p.cfg.Sort).Asc())
+	}
+	sorters = append(sorters, elastic.NewFieldSort("_id").Asc())
+	q := p.client.Search(p.indices...).SearchSource(p.source().SortBy(sorters...).SearchAfter(after...)).Size(p.cfg.ScrollSize)
+	if p.fetchSource != nil {
+		q = q.FetchSourceContext(p.fetchSource)
+	}
+	return q
+}
+
+// replace switches to the pages of a fallback or reconnected scroll
+func (p *pageSource) replace(next <-chan scrollBatch) {
+	go drainBatches(p.batches)
+	p.batches = next
+}
+
+// next returns the next page, or false once every page was delivered. A
+// stopped ctx is returned as the error of a page, never as the end of the
+// results.
+func (p *pageSource) next(ctx context.Context) (scrollBatch, bool) {
+	for {
+		batch, ok := <-p.batches
+		if !ok && ctx.Err() == nil {
+			// every slice is exhausted
+			return batch, false
+		} else if !ok {
+			// a stop the producers didn't report still ends in the interrupt
+			// or timeout handling, never as a complete export
+			batch = scrollBatch{err: ctx.Err()}
+		}
+		err := batch.err
+		if !p.started && err != nil && ctx.Err() == nil && !p.checkpointed && p.cfg.Workers == 1 && scrollUnavailable(err) {
+			// nothing was written yet, so start over with the fallback
+			warnf("the cluster refused the scroll (%s), paginating with search_after instead", err)
+			p.replace(searchAfterPages(p.ctx, p.searchAfter, nil))
+			continue
+		}
+		// a dropped connection or an expired scroll context is continued on a
+		// fresh connection, a single scroll from its scroll id or the sort values
+		// of the last hit. Errors like a failed login or a malformed query would
+		// fail the same way again and end the export right away.
+		if err != nil && ctx.Err() == nil && p.cfg.Workers == 1 && p.reconnects < scrollReconnects &&
+			(retryable(err) && (p.position.ScrollID != "" || p.position.SearchAfter != nil) || scrollLost(err) && p.position.SearchAfter != nil) {
+			p.reconnects++
+			warnf("lost the scroll (%s), reconnecting, attempt %d of %d", err, p.reconnects, scrollReconnects)
+			fresh, connErr := connect(p.cfg, clientOptions...)
+			if connErr == nil {
+				// the searches are built on the new client
+				p.client = fresh
+				p.replace(checkpointPages(p.ctx, p.position.ScrollID, p.position.SearchAfter, p.continueScroll, p.searchAfter))
+				continue
+			}
+			batch.err = connErr
+		}
+		p.started = true
+		return batch, true
+	}
+}
+
+// advance moves the position past a written page
+func (p *pageSource) advance(batch scrollBatch) {
+	p.position.advance(batch)
+	p.reconnects = 0
+}
+
+// close stops the producers and discards their last pages
+func (p *pageSource) close() {
+	p.stop()
+	drainBatches(p.batches)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCloseBatchesReportsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	batches := make(chan scrollBatch)
+	go closeBatches(ctx, batches)
+	batch, ok := <-batches
+	if !ok || batch.err != context.Canceled {
+		t.Fatalf("first receive = %+v, %v, want the context error", batch, ok)
+	}
+	if _, ok := <-batches; ok {
+		t.Error("batches still open after the context error")
+	}
+}
+
+func TestCloseBatchesComplete(t *testing.T) {
+	batches := make(chan scrollBatch)
+	go closeBatches(context.Background(), batches)
+	if batch, ok := <-batches; ok {
+		t.Errorf("receive = %+v, want a closed channel for a complete scroll", batch)
+	}
+}
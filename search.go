@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+)
+
+// searchParams are the search parameters, a search sets exactly one of them
+var searchParams = []string{"domain", "email", "email-pattern", "contains", "pass", "pass-file", "ip", "user", "hash", "phone", "query-file"}
+
+// setSearchParams returns the names of the search parameters set in cfg, in
+// the order of searchParams
+func setSearchParams(cfg Config) []string {
+	values := map[string]string{
+		"domain":        cfg.Domain,
+		"email":         cfg.Email,
+		"email-pattern": cfg.EmailPattern,
+		"contains":      cfg.Contains,
+		"pass":          cfg.Pass,
+		"pass-file":     cfg.PassFile,
+		"ip":            cfg.IP,
+		"user":          cfg.User,
+		"hash":          cfg.Hash,
+		"phone":         cfg.Phone,
+		"query-file":    cfg.QueryFile,
+	}
+	var set []string
+	for _, name := range searchParams {
+		if values[name] != "" {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// buildQuery returns the query described by the search parameters of cfg
+func buildQuery(cfg Config) hoardd.Query {
+	return hoardd.Query{
+		Email:          cfg.Email,
+		EmailPattern:   cfg.EmailPattern,
+		Contains:       cfg.Contains,
+		Domain:         cfg.Domain,
+		Pass:           cfg.Pass,
+		Passes:         cfg.Passes,
+		IP:             cfg.IP,
+		IPField:        cfg.IPField,
+		CIDR:           cfg.CIDR,
+		User:           cfg.User,
+		Hash:           cfg.Hash,
+		HashField:      cfg.HashField,
+		Phone:          cfg.Phone,
+		PhoneField:     cfg.PhoneField,
+		NormalizeEmail: cfg.NormalizeEmail,
+		MinShouldMatch: cfg.MinShouldMatch,
+		After:          cfg.After,
+		Before:         cfg.Before,
+		DateField:      cfg.DateField,
+		Raw:            cfg.RawQuery,
+	}
+}
+
+// loadQueryFile reads a raw query from path, either the query object itself
+// or a search body with the query under "query"
+func loadQueryFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var query map[string]json.RawMessage
+	if err := json.Unmarshal(data, &query); err != nil {
+		return "", fmt.Errorf("%s is not a JSON query object: %s", path, err)
+	}
+	if inner, ok := query["query"]; ok {
+		// sizes, sorts and the like come from the flags
+		if len(query) > 1 {
+			return "", fmt.Errorf("%s is a search body with more than a query, keep only the query", path)
+		}
+		if err := json.Unmarshal(inner, &query); err != nil {
+			return "", fmt.Errorf("the query in %s is not a JSON object: %s", path, err)
+		}
+		data = inner
+	}
+	if len(query) == 0 {
+		return "", fmt.Errorf("%s holds an empty query", path)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// maxPasswords is the most candidates of a pass file, the default
+// index.max_terms_count a terms query may hold
+const maxPasswords = 65536
+
+// loadPasswords reads the password candidates in path, one per line and
+// matched exactly, so only empty lines and duplicates are skipped
+func loadPasswords(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var passwords []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		password := strings.TrimSuffix(line, "\r")
+		if password == "" || seen[password] {
+			continue
+		}
+		seen[password] = true
+		passwords = append(passwords, password)
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("%s lists no passwords", path)
+	} else if len(passwords) > maxPasswords {
+		return nil, fmt.Errorf("%s lists %d passwords, at most %d can be searched at once", path, len(passwords), maxPasswords)
+	}
+	return passwords, nil
+}
+
+// validateSearch checks that cfg sets exactly one search parameter, naming
+// the conflicting ones otherwise. An email-pattern without @ may be combined
+// with domain, which it is matched in.
+func validateSearch(cfg Config) error {
+	set := setSearchParams(cfg)
+	if cfg.EmailPattern != "" && cfg.Domain != "" {
+		if strings.Contains(cfg.EmailPattern, "@") {
+			return usageError("email-pattern must be a local part pattern without @ when combined with domain")
+		}
+		set = set[1:]
+	}
+	if len(set) == 0 {
+		return usageError("an argument for one of the following parameters must be supplied: " +
+			strings.Join(searchParams, ", "))
+	} else if len(set) > 1 {
+		return usagef("%s are mutually exclusive, only one search parameter can receive a value", strings.Join(set, " and "))
+	}
+	if cfg.Contains != "" {
+		if strings.ContainsAny(strings.TrimSpace(cfg.Contains), " \t") {
+			return usageError("contains must be a single fragment of an email without whitespace")
+		} else if !cfg.AllowSlow {
+			// exact searches stay the fast default, this one is opted into
+			return usageError("contains matches the fragment anywhere in the email with a leading wildcard, " +
+				"which scans every indexed email and can take minutes on large indices, set allow-slow to run it")
+		}
+	} else if cfg.IP != "" {
+		if _, err := hoardd.ParseIPSearch(cfg.IP); err != nil {
+			return usagef("Error parsing ip parameter: %s", err)
+		}
+	} else if cfg.Phone != "" {
+		if _, err := hoardd.NormalizePhone(cfg.Phone); err != nil {
+			return usagef("Error parsing phone parameter: %s", err)
+		}
+	}
+	return nil
+}
+
+// validate checks the settings of cfg before connecting, for every kind of
+// run. cfg.Batch names the batch run, if any, since several settings only
+// apply to a single search.
+func validate(cfg Config) error {
+	for _, pattern := range hoardd.SplitList(cfg.Index) {
+		if err := validIndexPattern(pattern); err != nil {
+			return usagef("Error parsing index parameter: %s", err)
+		}
+	}
+	if !outputFormats[cfg.Format] {
+		return usagef("Invalid format %q, must be csv, json, jsonl or hashcat", cfg.Format)
+	} else if cfg.Format == "json" && (cfg.ResumeFromLine > 0 || cfg.Batch == "clusters") {
+		// a JSON array can't be appended to or concatenated
+		return usageError("json format cannot be combined with resume-from-line or clusters, use jsonl instead")
+	} else if _, err := parseDelimiter(cfg.Delimiter); err != nil {
+		return usagef("Invalid delimiter %q: %s", cfg.Delimiter, err)
+	} else if cfg.Delimiter != "" && cfg.Format != "csv" {
+		return usageError("delimiter only applies to csv format")
+	}
+	if cfg.MaxFieldAction != "truncate" && cfg.MaxFieldAction != "skip" {
+		return usagef("Invalid max-field-action %q, must be truncate or skip", cfg.MaxFieldAction)
+	} else if cfg.MaxFieldLength < 0 {
+		return usageError("max-field-length must not be negative")
+	} else if cfg.SamplePerIndex < 0 || cfg.SamplePerIndex > maxSamplePerIndex {
+		return usagef("sample-per-index must be between 0 and %d", maxSamplePerIndex)
+	} else if cfg.ReindexTo != "" && cfg.SamplePerIndex > 0 {
+		return usageError("reindex-to and sample-per-index are mutually exclusive")
+	} else if cfg.ReindexTo != "" && containsString(hoardd.SplitList(cfg.Index), cfg.ReindexTo) {
+		return usageError("reindex-to must differ from the searched index")
+	} else if cfg.ReindexTo == "" && (cfg.ReindexURL != "" || cfg.ReindexBreachField != "") {
+		return usageError("reindex-url and reindex-breach-field require reindex-to")
+	} else if cfg.ResumeFromLine < 0 {
+		return usageError("resume-from-line must not be negative")
+	} else if cfg.ResumeFromLine > 0 && cfg.Sort == "" {
+		return usageError("resume-from-line requires a stable sort, set the sort parameter to the field used by the original export")
+	} else if cfg.ResumeFromLine > 0 && (cfg.ReindexTo != "" || cfg.SamplePerIndex > 0) {
+		return usageError("resume-from-line only applies to file exports")
+	} else if cfg.DedupField != "" && !isLeakField(cfg.DedupField) {
+		return usagef("Invalid dedup-field %q, must be one of %s", cfg.DedupField, strings.Join(encodableFields, ", "))
+	} else if (cfg.Dedup || cfg.DedupField != "") && (cfg.ReindexTo != "" || cfg.SamplePerIndex > 0) {
+		return usageError("dedup only applies to full file exports")
+	} else if cfg.Outfile == "-" && (cfg.ResumeFromLine > 0 || cfg.CacheDir != "" || cfg.Batch == "clusters" || cfg.Batch == "input-file") {
+		return usageError("outfile - (stdout) cannot be combined with resume-from-line, cache-dir, clusters or input-file")
+	} else if cfg.Append && (cfg.Format == "json" || cfg.Encrypt || cfg.Outfile == "-" || cfg.ResumeFromLine > 0 ||
+		cfg.Checkpoint != "" || cfg.ReindexTo != "" || cfg.EmbedQuery) {
+		// a json array or an age file cannot be continued
+		return usageError("append cannot be combined with json format, encrypt, stdout, resume-from-line, checkpoint, reindex-to or embed-query")
+	} else if cfg.Split < 0 {
+		return usageError("split must not be negative")
+	} else if cfg.Split > 0 && (cfg.ResumeFromLine > 0 || cfg.Checkpoint != "" || cfg.CacheDir != "" || cfg.Outfile == "-" || cfg.Append ||
+		cfg.ReindexTo != "" || cfg.SamplePerIndex > 0 || cfg.Batch == "clusters" || cfg.Batch == "input-file") {
+		// every part is a new file, checked before it replaces an earlier one
+		return usageError("split cannot be combined with resume-from-line, checkpoint, cache-dir, stdout, append, reindex-to, sample-per-index, clusters or input-file")
+	} else if cfg.SQLite != "" && (cfg.Outfile != "" || cfg.ReindexTo != "" || cfg.Format != "csv" || cfg.Gzip || cfg.Encrypt ||
+		cfg.Split > 0 || cfg.Checkpoint != "" || cfg.ResumeFromLine > 0 || cfg.Append || cfg.CacheDir != "" || cfg.SamplePerIndex > 0) {
+		return usageError("sqlite replaces the outfile and cannot be combined with outfile, reindex-to, json formats, gzip, encrypt, " +
+			"split, checkpoint, resume-from-line, append, cache-dir or sample-per-index")
+	} else if cfg.Checkpoint != "" && cfg.Sort == "" {
+		return usageError("checkpoint requires a stable sort, set the sort parameter to a sortable field")
+	} else if cfg.Checkpoint != "" && (cfg.ResumeFromLine > 0 || cfg.Workers > 1 || cfg.Dedup || cfg.DedupField != "" ||
+		cfg.ReindexTo != "" || cfg.SamplePerIndex > 0 || cfg.CacheDir != "") {
+		return usageError("checkpoint cannot be combined with resume-from-line, workers, dedup, reindex-to, sample-per-index or cache-dir")
+	} else if cfg.Checkpoint != "" && (cfg.Format == "json" || cfg.Gzip || cfg.Encrypt || cfg.Outfile == "-" ||
+		cfg.Batch != "") {
+		// continuing truncates the outfile to the last checkpoint
+		return usageError("checkpoint cannot be combined with json format, gzip, encrypt, stdout, clusters, input-file or jobs")
+	} else if cfg.SortBy != "" && !containsString(csvColumns, cfg.SortBy) {
+		return usagef("Invalid sort-by %q, must be one of %s", cfg.SortBy, strings.Join(csvColumns, ", "))
+	} else if cfg.SortBy != "" && (cfg.ReindexTo != "" || cfg.SQLite != "" || cfg.SamplePerIndex > 0 || cfg.FirstOnly ||
+		cfg.Split > 0 || cfg.Checkpoint != "" || cfg.ResumeFromLine > 0) {
+		// rows are only written once the scroll is complete
+		return usageError("sort-by cannot be combined with reindex-to, sqlite, sample-per-index, first-only, split, checkpoint or resume-from-line")
+	} else if cfg.FirstOnly && (cfg.SamplePerIndex > 0 || cfg.ReindexTo != "" || cfg.SQLite != "" || cfg.Split > 0 ||
+		cfg.Checkpoint != "" || cfg.ResumeFromLine > 0 || cfg.Workers > 1 || cfg.EmbedQuery) {
+		// the collapsed search is answered by a single page
+		return usageError("first-only cannot be combined with sample-per-index, reindex-to, sqlite, split, checkpoint, " +
+			"resume-from-line, workers or embed-query")
+	} else if cfg.EmbedQuery && (cfg.Format == "jsonl" || cfg.SQLite != "" || cfg.ReindexTo != "" || cfg.SamplePerIndex > 0 ||
+		cfg.Batch == "clusters" || cfg.Batch == "input-file") {
+		// merged and appended outputs would carry the metadata of a single search
+		return usageError("embed-query only applies to csv and json file exports, not to jsonl, sqlite, reindex-to, sample-per-index, clusters or input-file")
+	} else if cfg.Workers < 1 {
+		return usageError("workers must be at least 1")
+	} else if cfg.Rate < 0 {
+		return usageError("rate must not be negative")
+	} else if cfg.ScrollSize < 1 {
+		return usageError("scroll-size must be at least 1")
+	} else if cfg.ScrollKeepAlive < time.Second {
+		return usageError("scroll-keepalive must be at least 1s")
+	} else if cfg.Workers > 1 && (cfg.Sort != "" || cfg.ResumeFromLine > 0) {
+		// slices are written in whatever order their pages arrive
+		return usageError("workers cannot be combined with sort or resume-from-line, sliced exports have no stable row order")
+	} else if cfg.Columns != "" && (cfg.Format != "csv" || cfg.SQLite != "" || cfg.ReindexTo != "" || cfg.Fields != "") {
+		return usageError("columns only applies to csv output and cannot be combined with sqlite, reindex-to or fields")
+	} else if cfg.Fields != "" && cfg.ReindexTo != "" {
+		return usageError("fields cannot be combined with reindex-to, reindexing copies full documents")
+	} else if cfg.IncludeMeta && cfg.Columns != "" {
+		return usageError("include-meta cannot be combined with columns, select the _id, _index and _score columns instead")
+	} else if cfg.IncludeMeta && cfg.ReindexTo != "" {
+		return usageError("include-meta cannot be combined with reindex-to, reindexing copies full documents")
+	} else if cfg.Format == "hashcat" && (cfg.Fields != "" || cfg.IncludeMeta || cfg.IncludeRawIndex || cfg.Highlight ||
+		cfg.EmbedQuery || cfg.ReindexTo != "") {
+		// hashcat reads one hash per line and nothing else
+		return usageError("hashcat format writes only hashes and cannot be combined with fields, include-meta, " +
+			"include-raw-index, highlight, embed-query or reindex-to")
+	} else if cfg.Format == "hashcat" && cfg.HashField == "" {
+		return usageError("hashcat format needs a hash-field to read hashes from")
+	} else if cfg.HashcatUser && cfg.Format != "hashcat" {
+		return usageError("hashcat-user requires -format hashcat")
+	} else if cfg.EnrichOutfile != "" && (cfg.Format != "csv" || cfg.Columns != "" || cfg.SQLite != "" || cfg.ReindexTo != "" ||
+		cfg.Split > 0 || cfg.ResumeFromLine > 0 || cfg.Checkpoint != "" || cfg.Append || cfg.CacheDir != "" || cfg.Gzip ||
+		cfg.Encrypt || cfg.Batch != "") {
+		// both files are numbered by a single fresh export
+		return usageError("enrich-outfile only applies to a single csv export and cannot be combined with columns, sqlite, " +
+			"reindex-to, split, resume-from-line, checkpoint, append, cache-dir, gzip, encrypt, clusters, input-file or jobs")
+	} else if cfg.EnrichOutfile != "" && cfg.EnrichOutfile == cfg.Outfile {
+		return usageError("enrich-outfile must differ from outfile")
+	} else if cfg.MaskPasswords && cfg.NoPasswordOutput {
+		return usageError("mask-passwords and no-password-output are mutually exclusive")
+	} else if cfg.RequirePassword && cfg.NoPasswordOutput {
+		// passwords are never fetched, so every result would be skipped
+		return usageError("require-password and no-password-output are mutually exclusive")
+	} else if cfg.Sample < 0 || cfg.Sample > maxSample {
+		return usagef("sample must be between 1 and %d", maxSample)
+	} else if cfg.Sample > 0 && (cfg.CountOnly || cfg.Summary || cfg.ListFields || cfg.ReindexTo != "" || cfg.SQLite != "" ||
+		cfg.SamplePerIndex > 0 || cfg.FirstOnly || cfg.Outfile != "") {
+		// the preview always goes to stdout
+		return usageError("sample cannot be combined with count-only, summary, list-fields, reindex-to, sqlite, sample-per-index, first-only or outfile")
+	} else if cfg.Summary && (cfg.CountOnly || cfg.ListFields || cfg.ReindexTo != "" || cfg.SamplePerIndex > 0 || cfg.ResumeFromLine > 0) {
+		return usageError("summary cannot be combined with count-only, list-fields, reindex-to, sample-per-index or resume-from-line")
+	} else if cfg.CountOnly && (cfg.ListFields || cfg.ReindexTo != "" || cfg.SamplePerIndex > 0 || cfg.ResumeFromLine > 0) {
+		return usageError("count-only cannot be combined with list-fields, reindex-to, sample-per-index or resume-from-line")
+	} else if cfg.MinScore < 0 {
+		return usageError("min-score cannot be negative")
+	} else if cfg.MinScore > 0 && cfg.SamplePerIndex > 0 {
+		// samples are scored randomly, a threshold would drop arbitrary hits
+		return usageError("min-score cannot be combined with sample-per-index")
+	} else if cfg.ExpectCount < -1 {
+		return usageError("expect-count cannot be negative, set it to -1 to disable")
+	} else if cfg.ExpectTolerance < 0 {
+		return usageError("expect-tolerance cannot be negative")
+	} else if cfg.ExpectTolerance > 0 && cfg.ExpectCount < 0 {
+		return usageError("expect-tolerance requires expect-count")
+	} else if cfg.ExpectCount >= 0 && (cfg.ListFields || cfg.Sample > 0 || cfg.Summary || cfg.SamplePerIndex > 0 || cfg.FirstOnly) {
+		// these searches run without the count query
+		return usageError("expect-count cannot be combined with list-fields, sample, summary, sample-per-index or first-only")
+	} else if cfg.ExpectCount >= 0 && (cfg.CacheDir != "" || cfg.Batch != "") {
+		// a cache hit skips the count, and every cluster, term or job counts a different total
+		return usageError("expect-count cannot be combined with cache-dir, clusters, input-file or jobs")
+	} else if cfg.Encrypt && (cfg.ReindexTo != "" || cfg.ListFields || cfg.CountOnly || cfg.Summary) {
+		return usageError("encrypt only applies to file exports")
+	} else if cfg.Encrypt && (cfg.ResumeFromLine > 0 || cfg.CacheDir != "" || cfg.Batch == "clusters") {
+		// these read or append to the outfile as plaintext
+		return usageError("encrypt cannot be combined with resume-from-line, cache-dir or clusters")
+	}
+	if _, err := parseFieldEncodings(cfg.EncodeFields); err != nil {
+		return usagef("Error parsing encode-fields parameter: %s", err)
+	}
+	if cfg.Columns != "" {
+		layout, err := parseColumns(cfg.Columns)
+		if err != nil {
+			return usagef("Error parsing columns parameter: %s", err)
+		}
+		for _, column := range layout {
+			if column.name == "password" && cfg.NoPasswordOutput {
+				return usageError("columns cannot include password with no-password-output")
+			} else if column.name == "matched_context" && !cfg.Highlight {
+				return usageError("the matched_context column requires highlight")
+			}
+		}
+	}
+	// date range bounds
+	var after, before time.Time
+	var err error
+	if cfg.After != "" {
+		if after, err = time.Parse(time.RFC3339, cfg.After); err != nil {
+			return usagef("Error parsing after parameter %q, must be an RFC3339 time such as 2020-01-01T00:00:00Z", cfg.After)
+		}
+	}
+	if cfg.Before != "" {
+		if before, err = time.Parse(time.RFC3339, cfg.Before); err != nil {
+			return usagef("Error parsing before parameter %q, must be an RFC3339 time such as 2020-01-01T00:00:00Z", cfg.Before)
+		}
+	}
+	if cfg.After != "" && cfg.Before != "" && !after.Before(before) {
+		return usageError("after must be earlier than before")
+	} else if (cfg.After != "" || cfg.Before != "") && cfg.DateField == "" {
+		return usageError("after and before require a date-field")
+	}
+	if cfg.Proxy != "" {
+		if _, err := parseProxy(cfg.Proxy); err != nil {
+			return usagef("Error parsing proxy parameter: %s", err)
+		}
+	}
+	if cfg.CACert != "" {
+		if _, err := os.Stat(cfg.CACert); err != nil {
+			return usagef("Error reading ca-cert parameter: %s", err)
+		}
+	}
+	if cfg.Batch != "clusters" {
+		if _, err := url.ParseRequestURI(cfg.InputURL); err != nil {
+			return usagef("Error parsing url parameter: %s", cfg.InputURL)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSearchParams(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		set  []string
+		ok   bool
+	}{
+		{"none", Config{}, nil, false},
+		{"email", Config{Email: "user@example.com"}, []string{"email"}, true},
+		{"domain", Config{Domain: "example.com"}, []string{"domain"}, true},
+		{"pass file", Config{PassFile: "candidates.txt"}, []string{"pass-file"}, true},
+		{"email and pass", Config{Email: "user@example.com", Pass: "hunter2"}, []string{"email", "pass"}, false},
+		{"three", Config{Domain: "example.com", User: "jsmith", Hash: "5f4dcc3b"}, []string{"domain", "user", "hash"}, false},
+		// a local part pattern narrows the domain search instead of competing with it
+		{"domain and pattern", Config{Domain: "example.com", EmailPattern: "admin*"}, []string{"domain", "email-pattern"}, true},
+		{"domain and full pattern", Config{Domain: "example.com", EmailPattern: "admin*@example.com"}, []string{"domain", "email-pattern"}, false},
+		{"bad ip", Config{IP: "10.0.0"}, []string{"ip"}, false},
+		{"contains without allow-slow", Config{Contains: "jsmith"}, []string{"contains"}, false},
+		{"contains", Config{Contains: "jsmith", AllowSlow: true}, []string{"contains"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if set := setSearchParams(tt.cfg); !reflect.DeepEqual(set, tt.set) {
+				t.Errorf("setSearchParams = %q, want %q", set, tt.set)
+			}
+			err := validateSearch(tt.cfg)
+			var usage usageError
+			if tt.ok && err != nil {
+				t.Errorf("validateSearch = %v, want nil", err)
+			} else if !tt.ok && !errors.As(err, &usage) {
+				t.Errorf("validateSearch = %v, want a usage error", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	base := defaultConfig()
+	base.InputURL = "https://localhost:9200"
+	tests := []struct {
+		name string
+		edit func(cfg *Config)
+		ok   bool
+	}{
+		{"defaults", func(cfg *Config) {}, true},
+		{"bad url", func(cfg *Config) { cfg.InputURL = "localhost" }, false},
+		// every cluster brings its own url
+		{"clusters without url", func(cfg *Config) { cfg.InputURL, cfg.Batch = "", "clusters" }, true},
+		{"json clusters", func(cfg *Config) { cfg.Format, cfg.Batch = "json", "clusters" }, false},
+		{"split", func(cfg *Config) { cfg.Split = 1000 }, true},
+		{"split input-file", func(cfg *Config) { cfg.Split, cfg.Batch = 1000, "input-file" }, false},
+		{"expect-count", func(cfg *Config) { cfg.ExpectCount = 10 }, true},
+		{"expect-count jobs", func(cfg *Config) { cfg.ExpectCount, cfg.Batch = 10, "jobs" }, false},
+		{"hashcat-user", func(cfg *Config) { cfg.Format, cfg.HashcatUser = "hashcat", true }, true},
+		{"hashcat-user csv", func(cfg *Config) { cfg.HashcatUser = true }, false},
+		{"after before", func(cfg *Config) { cfg.After, cfg.Before = "2021-01-01T00:00:00Z", "2020-01-01T00:00:00Z" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.edit(&cfg)
+			err := validate(cfg)
+			var usage usageError
+			if tt.ok && err != nil {
+				t.Errorf("validate = %v, want nil", err)
+			} else if !tt.ok && !errors.As(err, &usage) {
+				t.Errorf("validate = %v, want a usage error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals print a progress snapshot without stopping the export
+var statusSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGQUIT}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// statusSignals print a progress snapshot without stopping the export, windows
+// has no equivalent of SIGUSR1/SIGQUIT
+var statusSignals []os.Signal
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// pure Go driver, release builds stay free of cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTable is the table SQLite output is inserted into
+const sqliteTable = "leaks"
+
+// sqliteWriter inserts rows into a SQLite database, one transaction per batch
+type sqliteWriter struct {
+	db      *sql.DB
+	columns []string
+	tx      *sql.Tx
+	insert  *sql.Stmt
+}
+
+// openSQLite opens or creates the database at path, with the leaks table and
+// an index on email, for inserting rows of the given columns
+func openSQLite(path string, columns []string) (*sqliteWriter, error) {
+	for _, column := range columns {
+		if !containsString(csvColumns, column) {
+			return nil, fmt.Errorf("no %s column in the %s table", column, sqliteTable)
+		}
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// the table has every column, an export fills the ones it writes so
+	// repeated exports into one database line up
+	definitions := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		definitions[i] = column + " TEXT"
+	}
+	schema := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", sqliteTable, strings.Join(definitions, ", ")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_email ON %s (email)", sqliteTable, sqliteTable),
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqliteWriter{db: db, columns: columns}, nil
+}
+
+// write inserts a row, starting a transaction if none is open
+func (s *sqliteWriter) write(values []string) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(s.columns)), ", ")
+		insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			sqliteTable, strings.Join(s.columns, ", "), placeholders))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		s.tx, s.insert = tx, insert
+	}
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	_, err := s.insert.Exec(args...)
+	return err
+}
+
+// flush commits the rows written since the last flush
+func (s *sqliteWriter) flush() error {
+	if s.tx == nil {
+		return nil
+	}
+	// committing also closes the prepared statement
+	err := s.tx.Commit()
+	s.tx, s.insert = nil, nil
+	return err
+}
+
+// Close commits outstanding rows and closes the database, closing it again
+// does nothing
+func (s *sqliteWriter) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	s.db = nil
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exportStats tracks rows written per breach, safe for concurrent use
+type exportStats struct {
+	mu        sync.Mutex
+	written   int64
+	perBreach map[string]int64
+}
+
+func newExportStats() *exportStats {
+	return &exportStats{perBreach: make(map[string]int64)}
+}
+
+// record counts a row written for breach
+func (s *exportStats) record(breach string) {
+	s.mu.Lock()
+	s.written++
+	s.perBreach[breach]++
+	s.mu.Unlock()
+}
+
+// count returns the number of rows written
+func (s *exportStats) count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
+
+// progressTotal returns the number of hits progress is measured against, the
+// total of the count query or the limit that stops the export before it
+func progressTotal(total int64, limit int) int64 {
+	if limit > 0 && int64(limit) < total {
+		return int64(limit)
+	}
+	return total
+}
+
+// progress summarizes the progress in one line, the caller holds s.mu
+func (s *exportStats) progress(processed, total int64, started time.Time) string {
+	// documents indexed during the export can push processed past the count
+	if processed > total {
+		total = processed
+	}
+	elapsed := time.Since(started)
+	rate := float64(processed) / elapsed.Seconds()
+	eta := "unknown"
+	if rate > 0 {
+		eta = (time.Duration(float64(total-processed)/rate) * time.Second).Round(time.Second).String()
+	}
+	return fmt.Sprintf("processed %d/%d (%.1f%%), %.0f hits/sec, elapsed %s, ETA %s, written %d",
+		processed, total, 100*float64(processed)/float64(total), rate, elapsed.Round(time.Second), eta, s.written)
+}
+
+// print writes a status snapshot given the number of processed hits
+func (s *exportStats) print(w io.Writer, processed, total int64, started time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "status: %s\n", s.progress(processed, total, started))
+	breaches := make([]string, 0, len(s.perBreach))
+	for breach := range s.perBreach {
+		breaches = append(breaches, breach)
+	}
+	sort.Slice(breaches, func(i, j int) bool {
+		return s.perBreach[breaches[i]] > s.perBreach[breaches[j]]
+	})
+	for _, breach := range breaches {
+		fmt.Fprintf(w, "  %s: %d\n", breach, s.perBreach[breach])
+	}
+}
+
+// watchStatus prints a status snapshot to stderr whenever a status signal
+// arrives, until stop is closed
+func watchStatus(s *exportStats, processed func() int64, total int64, started time.Time, stop <-chan struct{}) {
+	if len(statusSignals) == 0 {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, statusSignals...)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				s.print(os.Stderr, processed(), total, started)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// interval between progress lines when the progress bar is disabled
+const progressInterval = 30 * time.Second
+
+// watchProgress logs a progress line every progressInterval until stop is
+// closed, in place of the progress bar
+func watchProgress(s *exportStats, processed func() int64, total int64, started time.Time, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.mu.Lock()
+				line := s.progress(processed(), total, started)
+				s.mu.Unlock()
+				infof("progress: %s", line)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// interval between the throughput lines logged in verbose mode
+const throughputInterval = time.Minute
+
+// watchThroughput logs the rows written per second over the last
+// throughputInterval and the time remaining at that rate, every interval
+// until stop is closed. The remaining time is estimated from the hits not yet
+// processed, so rows skipped as empty or duplicate don't stall it.
+func watchThroughput(s *exportStats, processed func() int64, total int64, stop <-chan struct{}) {
+	ticker := time.NewTicker(throughputInterval)
+	go func() {
+		defer ticker.Stop()
+		last, lastWritten, lastProcessed := time.Now(), s.count(), processed()
+		for {
+			select {
+			case now := <-ticker.C:
+				written, done := s.count(), processed()
+				seconds := now.Sub(last).Seconds()
+				rowRate := float64(written-lastWritten) / seconds
+				hitRate := float64(done-lastProcessed) / seconds
+				eta := "unknown"
+				if hitRate > 0 {
+					eta = (time.Duration(float64(total-done)/hitRate) * time.Second).Round(time.Second).String()
+				}
+				debugf("throughput: %.0f rows/sec over the last %s, %d of %d hits left, ETA %s",
+					rowRate, throughputInterval, total-done, total, eta)
+				last, lastWritten, lastProcessed = now, written, done
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// interval between -json-log progress events
+const jsonLogInterval = 10 * time.Second
+
+// jsonEvent is a structured progress record written by -json-log
+type jsonEvent struct {
+	Event     string `json:"event"`
+	Written   int64  `json:"written"`
+	Processed int64  `json:"processed"`
+	Total     int64  `json:"total"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+	Outfile   string `json:"outfile,omitempty"`
+	// Version identifies the build that produced the export, done events only
+	Version string `json:"version,omitempty"`
+}
+
+// event returns the current progress as an event of the given kind
+func (s *exportStats) event(kind string, processed, total int64, started time.Time) jsonEvent {
+	if processed > total {
+		total = processed
+	}
+	return jsonEvent{
+		Event:     kind,
+		Written:   s.count(),
+		Processed: processed,
+		Total:     total,
+		ElapsedMS: time.Since(started).Milliseconds(),
+	}
+}
+
+// writeEvent writes e as a single JSON line
+func writeEvent(w io.Writer, e jsonEvent) {
+	// the event only holds numbers and strings, which always encode
+	json.NewEncoder(w).Encode(e)
+}
+
+// watchJSONProgress writes a progress event every jsonLogInterval until stop
+// is closed
+func watchJSONProgress(w io.Writer, s *exportStats, processed func() int64, total int64, started time.Time, stop <-chan struct{}) {
+	ticker := time.NewTicker(jsonLogInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				writeEvent(w, s.event("progress", processed(), total, started))
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// a count beyond what an int holds on 32-bit builds
+const overInt32 int64 = 3000000000
+
+func TestProgressTotal(t *testing.T) {
+	tests := []struct {
+		total int64
+		limit int
+		want  int64
+	}{
+		{1500, 0, 1500},
+		{1500, 1000, 1000},
+		{1500, 2000, 1500},
+		{overInt32, 0, overInt32},
+		{overInt32, 1000000, 1000000},
+	}
+	for _, tt := range tests {
+		if got := progressTotal(tt.total, tt.limit); got != tt.want {
+			t.Errorf("progressTotal(%d, %d) = %d, want %d", tt.total, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestProgressOverInt32(t *testing.T) {
+	bar := pb.New64(progressTotal(overInt32, 0))
+	if bar.Total() != overInt32 {
+		t.Errorf("bar total = %d, want %d", bar.Total(), overInt32)
+	}
+	processed := overInt32 - 1000
+	bar.SetCurrent(processed)
+	if bar.Current() != processed {
+		t.Errorf("bar current = %d, want %d", bar.Current(), processed)
+	}
+
+	s := newExportStats()
+	e := s.event("progress", bar.Current(), bar.Total(), time.Now().Add(-time.Hour))
+	if e.Processed != processed || e.Total != overInt32 {
+		t.Errorf("event processed/total = %d/%d, want %d/%d", e.Processed, e.Total, processed, overInt32)
+	}
+	line := s.progress(bar.Current(), bar.Total(), time.Now().Add(-time.Hour))
+	if want := "processed 2999999000/3000000000 (100.0%)"; !strings.HasPrefix(line, want) {
+		t.Errorf("progress = %q, want it to start with %q", line, want)
+	}
+}
+
+func TestProgressPastTotal(t *testing.T) {
+	s := newExportStats()
+	line := s.progress(1200, 1000, time.Now().Add(-time.Minute))
+	if want := "processed 1200/1200 (100.0%)"; !strings.HasPrefix(line, want) {
+		t.Errorf("progress = %q, want it to start with %q", line, want)
+	}
+	if strings.Contains(line, "ETA -") {
+		t.Errorf("progress = %q, want no negative ETA", line)
+	}
+	if e := s.event("done", 1200, 1000, time.Now()); e.Total != 1200 {
+		t.Errorf("event total = %d, want 1200", e.Total)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// breachCount is the number of matching accounts in one breach
+type breachCount struct {
+	Breach string
+	Count  int64
+}
+
+// breachCounts converts the buckets of a terms aggregation on _index into
+// breach counts sorted by count, largest first
+func breachCounts(agg *elastic.AggregationBucketKeyItems) []breachCount {
+	counts := make([]breachCount, 0, len(agg.Buckets))
+	for _, bucket := range agg.Buckets {
+		counts = append(counts, breachCount{Breach: hoardd.BreachName(fmt.Sprint(bucket.Key)), Count: bucket.DocCount})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Breach < counts[j].Breach
+	})
+	return counts
+}
+
+// printSummary writes breach counts as a table, with the accounts in breaches
+// beyond the aggregation size as other
+func printSummary(w io.Writer, counts []breachCount, other int64) {
+	width := len("breach")
+	var total int64
+	for _, c := range counts {
+		if len(c.Breach) > width {
+			width = len(c.Breach)
+		}
+		total += c.Count
+	}
+	total += other
+	fmt.Fprintf(w, "%-*s %12s\n", width, "breach", "count")
+	fmt.Fprintf(w, "%s\n", strings.Repeat("-", width+13))
+	for _, c := range counts {
+		fmt.Fprintf(w, "%-*s %12d\n", width, c.Breach, c.Count)
+	}
+	if other > 0 {
+		fmt.Fprintf(w, "%-*s %12d\n", width, "(other)", other)
+	}
+	fmt.Fprintf(w, "%s\n", strings.Repeat("-", width+13))
+	fmt.Fprintf(w, "%-*s %12d\n", width, "total", total)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+// build metadata, stamped at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// versionString describes the build, i.e. hoardd-client v1.2.0 (commit abc1234, built 2020-01-01T00:00:00Z)
+func versionString() string {
+	return fmt.Sprintf("hoardd-client %s (commit %s, built %s)", version, commit, date)
+}
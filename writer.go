@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"sort"
+	"strings"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/hoardd/hoardd-client/hoardd"
+	"github.com/olivere/elastic/v7"
+)
+
+// exportWriter writes the hits of every page to the output of an export,
+// skipping malformed, empty, duplicate and already written results
+type exportWriter struct {
+	cfg   Config
+	rows  *rowFormat
+	o     *exportOutput
+	stats *exportStats
+	bar   *pb.ProgressBar
+	// a single buffered writer for the whole export, flushed once per page
+	w     *bufio.Writer
+	dedup *dedupFilter
+	// rows already present in the outfile when resuming
+	skip int
+	// rows in the current part of a split export
+	partRows int
+	// rows held back by sort-by, written in order when the export finishes
+	sorted []sortedRow
+	// results dropped for an empty email, password or hash, or an unreadable source
+	emptyEmails, emptyPasswords, emptyHashes, malformed int
+	// results dropped for a null value such as null or n/a in place of one
+	nullResults int
+	// _source bytes received, to show what field selection saves
+	sourceBytes int64
+	// set once the export stopped at the limit
+	limited bool
+}
+
+// newExportWriter returns the writer of an export to o, starting the output
+// with its header unless appended output already has one
+func newExportWriter(cfg Config, rows *rowFormat, o *exportOutput, stats *exportStats, bar *pb.ProgressBar) (*exportWriter, error) {
+	e := &exportWriter{cfg: cfg, rows: rows, o: o, stats: stats, bar: bar, w: bufio.NewWriter(o.out), skip: cfg.ResumeFromLine}
+	if cfg.Dedup || cfg.DedupField != "" {
+		e.dedup = newDedupFilter(cfg.DedupField)
+	}
+	//print headers, appended output may already have them
+	if o.bulk == nil && o.db == nil && o.writeHeader {
+		if _, err := e.w.WriteString(rows.header()); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// atLimit reports whether the export received as many hits as the limit allows
+func (e *exportWriter) atLimit() bool {
+	if e.cfg.Limit != 0 && e.bar.Current() >= int64(e.cfg.Limit) {
+		e.limited = true
+	}
+	return e.limited
+}
+
+// empty reports whether a result is skipped for value, counting an empty
+// value in count and a null value in nullResults
+func (e *exportWriter) empty(value string, count *int) bool {
+	if value == "" {
+		*count++
+		return true
+	} else if hoardd.IsNullValue(value, e.rows.nullValues) {
+		e.nullResults++
+		return true
+	}
+	return false
+}
+
+// write writes the hits of a page, up to the limit
+func (e *exportWriter) write(hits []*elastic.SearchHit) error {
+	cfg, rows := e.cfg, e.rows
+	for _, hit := range hits {
+		// a page can hold more hits than the limit leaves
+		if e.atLimit() {
+			break
+		}
+		e.sourceBytes += int64(len(hit.Source))
+		if cfg.Debug {
+			debugf("Hit: %s", hit.Source)
+		}
+		// copy the untouched document when reindexing
+		if e.o.bulk != nil {
+			doc, err := reindexDoc(hit, cfg.ReindexBreachField)
+			if err != nil {
+				warnf("skipping malformed hit %s: %s", hit.Id, err)
+			} else {
+				e.o.bulk.Add(elastic.NewBulkIndexRequest().Index(cfg.ReindexTo).Id(hit.Id).Doc(doc))
+				e.stats.record(hoardd.BreachName(hit.Index))
+			}
+			e.bar.Increment()
+			continue
+		}
+		l, err := hoardd.DecodeLeak(hit.Source)
+		if err != nil {
+			// one bad document must not end a long export
+			warnf("skipping malformed hit %s in %s: %s", hit.Id, hit.Index, err)
+			e.malformed++
+			e.bar.Increment()
+			continue
+		}
+		// guard against pathologically large fields
+		if !limitField("email", &l.Email, cfg.MaxFieldLength, cfg.MaxFieldAction) ||
+			!limitField("password", &l.Password, cfg.MaxFieldLength, cfg.MaxFieldAction) {
+			e.bar.Increment()
+			continue
+		}
+		// eliminate empty/null results, bare hashcat lines carry no email
+		if !cfg.IncludeEmpty && (rows.format != "hashcat" || rows.hashcatUser) && e.empty(l.Email, &e.emptyEmails) ||
+			cfg.RequirePassword && e.empty(l.Password, &e.emptyPasswords) ||
+			rows.format == "hashcat" && e.empty(rows.hash(l, hit), &e.emptyHashes) {
+			e.bar.Increment()
+			continue
+		}
+		if e.dedup != nil && e.dedup.duplicate(l) {
+			e.bar.Increment()
+			continue
+		}
+		if e.skip > 0 {
+			e.skip--
+		} else if e.o.db != nil {
+			if err := e.o.db.write(rows.values(l, hit)); err != nil {
+				return err
+			}
+			e.stats.record(hoardd.BreachName(hit.Index))
+		} else if cfg.SortBy != "" {
+			e.sorted = append(e.sorted, sortedRow{key: strings.ToLower(rows.value(cfg.SortBy, l, hit)), leak: l, hit: hit})
+			e.stats.record(hoardd.BreachName(hit.Index))
+		} else {
+			if e.o.part > 0 && e.partRows == cfg.Split {
+				if err := e.rollover(); err != nil {
+					return err
+				}
+			}
+			if _, err := e.w.WriteString(rows.row(l, hit)); err != nil {
+				return err
+			}
+			if e.o.side != nil {
+				if err := e.o.side.write(l, hit); err != nil {
+					return err
+				}
+			}
+			e.partRows++
+			e.stats.record(hoardd.BreachName(hit.Index))
+		}
+		e.bar.Increment()
+	}
+	// documents indexed during the export can outgrow the count
+	if e.bar.Current() > e.bar.Total() {
+		e.bar.SetTotal(e.bar.Current())
+	}
+	return nil
+}
+
+// flush writes out the rows of the last page, every page is inserted into
+// sqlite in one transaction
+func (e *exportWriter) flush() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.o.db != nil {
+		return e.o.db.flush()
+	}
+	return nil
+}
+
+// rollover closes the current part of a split export and starts the next
+func (e *exportWriter) rollover() error {
+	if _, err := e.w.WriteString(e.rows.footer()); err != nil {
+		return err
+	}
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if err := e.o.nextPart(); err != nil {
+		return err
+	}
+	e.w.Reset(e.o.out)
+	e.rows.n, e.partRows = 0, 0
+	_, err := e.w.WriteString(e.rows.header())
+	return err
+}
+
+// writeSorted writes the rows held back by sort-by in order
+func (e *exportWriter) writeSorted() error {
+	sort.SliceStable(e.sorted, func(i, j int) bool { return e.sorted[i].key < e.sorted[j].key })
+	for _, row := range e.sorted {
+		if _, err := e.w.WriteString(e.rows.row(row.leak, row.hit)); err != nil {
+			return err
+		}
+		if e.o.side != nil {
+			if err := e.o.side.write(row.leak, row.hit); err != nil {
+				return err
+			}
+		}
+	}
+	e.sorted = nil
+	return nil
+}
+
+// finish ends the output, flushes the reindex target and finalizes
+// compression and encryption, then logs the skipped results
+func (e *exportWriter) finish() error {
+	if e.o.bulk == nil && e.o.db == nil {
+		if err := e.writeSorted(); err != nil {
+			return err
+		}
+		if _, err := e.w.WriteString(e.rows.footer()); err != nil {
+			return err
+		}
+		if err := e.w.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := e.o.finish(); err != nil {
+		return err
+	}
+	if e.dedup != nil {
+		infof("suppressed %d duplicate results", e.dedup.suppressed)
+	}
+	if e.malformed > 0 {
+		infof("skipped %d malformed results", e.malformed)
+	}
+	if e.emptyEmails > 0 {
+		infof("suppressed %d results without an email", e.emptyEmails)
+	}
+	if e.emptyPasswords > 0 {
+		infof("suppressed %d results without a password", e.emptyPasswords)
+	}
+	if e.emptyHashes > 0 {
+		infof("suppressed %d results without a hash", e.emptyHashes)
+	}
+	if e.nullResults > 0 {
+		infof("suppressed %d results holding a null value (%s)", e.nullResults, strings.Join(e.rows.nullValues, ", "))
+	}
+	return nil
+}
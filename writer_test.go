@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/olivere/elastic/v7"
+)
+
+// testHits returns n hits of distinct emails in leak_test
+func testHits(n int) []*elastic.SearchHit {
+	hits := make([]*elastic.SearchHit, n)
+	for i := range hits {
+		source := fmt.Sprintf(`{"email":"user%d@example.com","password":"secret%d"}`, i, i)
+		hits[i] = &elastic.SearchHit{Id: fmt.Sprint(i), Index: "leak_test", Source: json.RawMessage(source)}
+	}
+	return hits
+}
+
+// testExport writes hits through a fresh output and writer for cfg and
+// returns the writer once the output is committed
+func testExport(t *testing.T, cfg Config, hits []*elastic.SearchHit) *exportWriter {
+	t.Helper()
+	rows := rowFormat{format: cfg.Format}
+	o, err := openOutput(context.Background(), nil, cfg, &rows, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.close()
+	ew, err := newExportWriter(cfg, &rows, o, newExportStats(), pb.New64(int64(len(hits))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.write(hits); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.commit(); err != nil {
+		t.Fatal(err)
+	}
+	return ew
+}
+
+// readLines returns the lines of the file name
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestExportWriterSplit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Outfile = filepath.Join(t.TempDir(), "out.csv")
+	cfg.Split = 2
+	testExport(t, cfg, testHits(3))
+	// every part starts with its own header
+	for part, want := range []int{3, 2} {
+		name := splitName(cfg.Outfile, part+1)
+		if lines := readLines(t, name); len(lines) != want {
+			t.Errorf("%s has %d lines, want %d: %q", name, len(lines), want, lines)
+		}
+	}
+}
+
+func TestExportWriterLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Outfile = filepath.Join(t.TempDir(), "out.jsonl")
+	cfg.Format = "jsonl"
+	cfg.Limit = 2
+	ew := testExport(t, cfg, testHits(5))
+	// the limit stops the writer inside the page
+	if !ew.limited || ew.bar.Current() != 2 {
+		t.Errorf("limited = %v after %d hits, want true after 2", ew.limited, ew.bar.Current())
+	}
+	if lines := readLines(t, cfg.Outfile); len(lines) != 2 {
+		t.Errorf("%s has %d lines, want 2: %q", cfg.Outfile, len(lines), lines)
+	}
+}